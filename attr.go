@@ -0,0 +1,138 @@
+package s3fs
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User metadata keys of the POSIX attributes of an object. Like s3fs-fuse,
+// the mode is stored as a decimal st_mode and the times as seconds since
+// the Unix epoch.
+const (
+	metaMode  = "mode"
+	metaUID   = "uid"
+	metaGID   = "gid"
+	metaAtime = "atime"
+	metaMtime = "mtime"
+)
+
+// File type and permission bits of st_mode.
+const (
+	unixTypeMask = 0170000
+	unixDir      = 0040000
+	unixRegular  = 0100000
+	unixSymlink  = 0120000
+	unixSetuid   = 04000
+	unixSetgid   = 02000
+	unixSticky   = 01000
+)
+
+// modeBits are the bits of an os.FileMode that can be changed with Chmod.
+const modeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// formatMode formats m as a decimal st_mode.
+func formatMode(m os.FileMode) string {
+	u := uint32(m.Perm())
+	switch {
+	case m&os.ModeDir != 0:
+		u |= unixDir
+	case m&os.ModeSymlink != 0:
+		u |= unixSymlink
+	default:
+		u |= unixRegular
+	}
+	if m&os.ModeSetuid != 0 {
+		u |= unixSetuid
+	}
+	if m&os.ModeSetgid != 0 {
+		u |= unixSetgid
+	}
+	if m&os.ModeSticky != 0 {
+		u |= unixSticky
+	}
+	return strconv.FormatUint(uint64(u), 10)
+}
+
+// parseMode parses a decimal st_mode.
+func parseMode(s string) (os.FileMode, bool) {
+	u, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	m := os.FileMode(u) & os.ModePerm
+	switch u & unixTypeMask {
+	case unixDir:
+		m |= os.ModeDir
+	case unixSymlink:
+		m |= os.ModeSymlink
+	}
+	if u&unixSetuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if u&unixSetgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if u&unixSticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m, true
+}
+
+// formatTime formats t as seconds since the Unix epoch, with a fractional
+// part only if t has sub-second precision.
+func formatTime(t time.Time) string {
+	sec, nsec := t.Unix(), t.Nanosecond()
+	if nsec == 0 {
+		return strconv.FormatInt(sec, 10)
+	}
+	return strconv.FormatInt(sec, 10) + "." + strings.TrimRight(strconv.Itoa(1e9 + nsec)[1:], "0")
+}
+
+// parseTime parses seconds since the Unix epoch, with an optional
+// fractional part.
+func parseTime(s string) (time.Time, bool) {
+	secStr, fracStr, hasFrac := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secStr, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	var nsec int64
+	if hasFrac {
+		if fracStr == "" || len(fracStr) > 9 || strings.Trim(fracStr, "0123456789") != "" {
+			return time.Time{}, false
+		}
+		nsec, _ = strconv.ParseInt((fracStr + "000000000")[:9], 10, 64)
+	}
+	return time.Unix(sec, nsec), true
+}
+
+// newAttrs returns the user metadata of a file created with perm, on top
+// of the default metadata defaults.
+func newAttrs(perm os.FileMode, defaults map[string]string) map[string]string {
+	meta := make(map[string]string, len(defaults)+1)
+	for k, v := range defaults {
+		meta[strings.ToLower(k)] = v
+	}
+	meta[metaMode] = formatMode(perm & modeBits)
+	return meta
+}
+
+// keptAttrs returns the user metadata meta of an object that is kept when
+// the object is overwritten: everything but its times, which the new
+// content makes obsolete.
+func keptAttrs(meta map[string]string) map[string]string {
+	if len(meta) == 0 {
+		return nil
+	}
+	kept := make(map[string]string, len(meta))
+	for k, v := range meta {
+		k = strings.ToLower(k)
+		if k != metaAtime && k != metaMtime {
+			kept[k] = v
+		}
+	}
+	return kept
+}
@@ -0,0 +1,92 @@
+package s3fs
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatParseMode(t *testing.T) {
+	tests := []struct {
+		name string
+		mode os.FileMode
+		want string
+	}{
+		{"regular file", 0644, "33188"},
+		{"executable", 0755, "33261"},
+		{"directory", os.ModeDir | 0755, "16877"},
+		{"symlink", os.ModeSymlink | 0777, "41471"},
+		{"setuid", os.ModeSetuid | 0755, "35309"},
+		{"sticky directory", os.ModeDir | os.ModeSticky | 0777, "17407"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatMode(tt.mode))
+
+			m, ok := parseMode(tt.want)
+			assert.True(t, ok)
+			assert.Equal(t, tt.mode, m)
+		})
+	}
+
+	for _, s := range []string{"", "abc", "-1", "99999999999"} {
+		_, ok := parseMode(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func TestFormatParseTime(t *testing.T) {
+	tests := []struct {
+		name string
+		time time.Time
+		want string
+	}{
+		{"whole seconds", time.Unix(1700000000, 0), "1700000000"},
+		{"milliseconds", time.Unix(1700000000, 123000000), "1700000000.123"},
+		{"nanoseconds", time.Unix(1700000000, 5), "1700000000.000000005"},
+		{"before epoch", time.Unix(-10, 0), "-10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatTime(tt.time))
+
+			got, ok := parseTime(tt.want)
+			assert.True(t, ok)
+			assert.True(t, tt.time.Equal(got))
+		})
+	}
+
+	for _, s := range []string{"", "abc", "1.", "1.x", "1.-5", "1.1234567890"} {
+		_, ok := parseTime(s)
+		assert.False(t, ok, s)
+	}
+}
+
+func TestNewAttrs(t *testing.T) {
+	assert.Equal(t, map[string]string{"mode": "33261"}, newAttrs(0755, nil))
+	// only the permission bits are stored
+	assert.Equal(t, map[string]string{"mode": "33188"}, newAttrs(os.ModeDir|0644, nil))
+}
+
+func TestKeptAttrs(t *testing.T) {
+	kept := keptAttrs(map[string]string{
+		"mode":   "33261",
+		"Uid":    "1000",
+		"gid":    "1000",
+		"atime":  "1700000000",
+		"Mtime":  "1700000000",
+		"custom": "value",
+	})
+	assert.Equal(t, map[string]string{
+		"mode":   "33261",
+		"uid":    "1000",
+		"gid":    "1000",
+		"custom": "value",
+	}, kept)
+
+	assert.Nil(t, keptAttrs(nil))
+}
@@ -0,0 +1,148 @@
+package s3fs
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+var _ billy.Change = (*S3FS)(nil)
+
+// Chmod changes the mode of the named file to mode, following symbolic
+// links. Only the permission, setuid, setgid and sticky bits are kept.
+func (fs *S3FS) Chmod(name string, mode os.FileMode) error {
+	if fs.opts.readOnly {
+		return &os.PathError{Op: "chmod", Path: name, Err: billy.ErrReadOnly}
+	}
+	key, err := fs.key(name)
+	if err != nil {
+		return fs.pathError("chmod", name, err)
+	}
+
+	err = fs.setAttrs(key, func(meta map[string]string, dir bool) {
+		m := mode & modeBits
+		if dir {
+			m |= os.ModeDir
+		}
+		meta[metaMode] = formatMode(m)
+	})
+	if err != nil {
+		return fs.pathError("chmod", name, err)
+	}
+	return nil
+}
+
+// Lchown changes the numeric uid and gid of the named file without
+// following symbolic links. A uid or gid of -1 is left unchanged.
+func (fs *S3FS) Lchown(name string, uid, gid int) error {
+	if fs.opts.readOnly {
+		return &os.PathError{Op: "lchown", Path: name, Err: billy.ErrReadOnly}
+	}
+	key, err := fs.lkey(name)
+	if err != nil {
+		return fs.pathError("lchown", name, err)
+	}
+
+	if err := fs.setAttrs(key, ownerAttrs(uid, gid)); err != nil {
+		return fs.pathError("lchown", name, err)
+	}
+	return nil
+}
+
+// Chown changes the numeric uid and gid of the named file, following
+// symbolic links. A uid or gid of -1 is left unchanged.
+func (fs *S3FS) Chown(name string, uid, gid int) error {
+	if fs.opts.readOnly {
+		return &os.PathError{Op: "chown", Path: name, Err: billy.ErrReadOnly}
+	}
+	key, err := fs.key(name)
+	if err != nil {
+		return fs.pathError("chown", name, err)
+	}
+
+	if err := fs.setAttrs(key, ownerAttrs(uid, gid)); err != nil {
+		return fs.pathError("chown", name, err)
+	}
+	return nil
+}
+
+// Chtimes changes the access and modification times of the named file,
+// following symbolic links. The modification time reported by Stat is
+// the one set here instead of the time the object was last written.
+func (fs *S3FS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	if fs.opts.readOnly {
+		return &os.PathError{Op: "chtimes", Path: name, Err: billy.ErrReadOnly}
+	}
+	key, err := fs.key(name)
+	if err != nil {
+		return fs.pathError("chtimes", name, err)
+	}
+
+	err = fs.setAttrs(key, func(meta map[string]string, dir bool) {
+		meta[metaAtime] = formatTime(atime)
+		meta[metaMtime] = formatTime(mtime)
+	})
+	if err != nil {
+		return fs.pathError("chtimes", name, err)
+	}
+	return nil
+}
+
+func ownerAttrs(uid, gid int) func(map[string]string, bool) {
+	return func(meta map[string]string, dir bool) {
+		if uid != -1 {
+			meta[metaUID] = strconv.Itoa(uid)
+		}
+		if gid != -1 {
+			meta[metaGID] = strconv.Itoa(gid)
+		}
+	}
+}
+
+// setAttrs updates the user metadata of the object key with update, which
+// is told whether key is a directory. The attributes of a directory are
+// stored on its marker object, which is created if the directory doesn't
+// have one. The root of the bucket has no attributes.
+func (fs *S3FS) setAttrs(key string, update func(meta map[string]string, dir bool)) error {
+	if key == "" {
+		return os.ErrInvalid
+	}
+	output, err := fs.headObject(key)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	dir := err != nil
+	if dir {
+		key = dirPrefix(key)
+		output, err = fs.headObject(key)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if err != nil {
+		// an implicit directory without marker object
+		keys, err := fs.listKeys(key, 1)
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return os.ErrNotExist
+		}
+
+		meta := map[string]string{}
+		update(meta, true)
+		return fs.writeObject(key, nil, meta, false)
+	}
+
+	meta := make(map[string]string, len(output.Metadata)+2)
+	for k, v := range output.Metadata {
+		meta[strings.ToLower(k)] = v
+	}
+	update(meta, dir)
+	return fs.replaceMetadata(key, output, meta)
+}
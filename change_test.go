@@ -0,0 +1,79 @@
+package s3fs
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestS3FS_Chmod(t *testing.T) {
+	fsys, client := newTestFS(t, WithContentType(ContentTypeByExtension))
+
+	require.NoError(t, util.WriteFile(fsys, "file.txt", []byte("data"), 0644))
+	require.NoError(t, fsys.Chmod("file.txt", 0755|os.ModeSetuid))
+	fi, err := fsys.Stat("file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, 0755|os.ModeSetuid, fi.Mode())
+
+	// the content and headers of the object are preserved
+	b, err := util.ReadFile(fsys, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(b))
+	head, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("file.txt"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", aws.ToString(head.ContentType))
+
+	// the attributes of an implicit directory go to a new marker
+	require.NoError(t, util.WriteFile(fsys, "dir/file", nil, 0644))
+	require.NoError(t, fsys.Chmod("dir", 0700))
+	fi, err = fsys.Stat("dir")
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0700, fi.Mode())
+	assert.Contains(t, client.Keys("bucket"), "dir/")
+
+	assert.ErrorIs(t, fsys.Chmod("missing", 0644), os.ErrNotExist)
+}
+
+func TestS3FS_ChownAndChtimes(t *testing.T) {
+	fsys, client := newTestFS(t)
+
+	require.NoError(t, util.WriteFile(fsys, "file", nil, 0644))
+	require.NoError(t, fsys.Chown("file", 1000, -1))
+	require.NoError(t, fsys.Lchown("file", -1, 100))
+	head, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("file"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1000", head.Metadata[metaUID])
+	assert.Equal(t, "100", head.Metadata[metaGID])
+
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 500000000, time.UTC)
+	require.NoError(t, fsys.Chtimes("file", mtime, mtime))
+	fi, err := fsys.Stat("file")
+	require.NoError(t, err)
+	assert.True(t, mtime.Equal(fi.ModTime()))
+	assert.Equal(t, os.FileMode(0644), fi.Mode())
+
+	// overwriting the file keeps the owner but not the times
+	require.NoError(t, util.WriteFile(fsys, "file", []byte("new"), 0600))
+	fi, err = fsys.Stat("file")
+	require.NoError(t, err)
+	assert.False(t, mtime.Equal(fi.ModTime()))
+	head, err = client.HeadObject(context.Background(), &s3.HeadObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("file"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1000", head.Metadata[metaUID])
+}
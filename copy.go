@@ -0,0 +1,165 @@
+package s3fs
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// maxCopyObjectSize is the size of the largest object that can be
+	// copied with a single CopyObject request.
+	maxCopyObjectSize = 5 << 30
+
+	// copyPartSize is the size of the parts of a multipart copy, unless
+	// the object is too large to fit in maxUploadParts parts of that size
+	// (see partSizeOf).
+	copyPartSize = 512 << 20
+
+	// copyConcurrency is the number of objects copied in parallel when
+	// a directory is renamed.
+	copyConcurrency = 4
+)
+
+// copyObject copies the object src of the given size and storage class to
+// dst on the server side. The copy keeps the class, which S3 would reset to
+// STANDARD otherwise. Objects larger than maxCopyObjectSize are copied with
+// a multipart upload.
+func (fs *S3FS) copyObject(src, dst string, size int64, class types.StorageClass) error {
+	if size > maxCopyObjectSize {
+		return fs.multipartCopy(src, dst, size, nil)
+	}
+
+	ctx, cancel := fs.opts.withTimeout(fs.ctx)
+	defer cancel()
+
+	_, err := fs.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:       aws.String(fs.bucket),
+		Key:          aws.String(dst),
+		CopySource:   aws.String(copySource(fs.bucket, src)),
+		StorageClass: class,
+	})
+	return mapError(err)
+}
+
+// replaceMetadata replaces the user metadata of the object key, described
+// by head, with meta by copying the object onto itself. The other headers
+// of the object are preserved. The copy fails if the object has changed
+// since head was retrieved.
+func (fs *S3FS) replaceMetadata(key string, head *s3.HeadObjectOutput, meta map[string]string) error {
+	if size := aws.ToInt64(head.ContentLength); size > maxCopyObjectSize {
+		return fs.multipartCopy(key, key, size, meta)
+	}
+
+	ctx, cancel := fs.opts.withTimeout(fs.ctx)
+	defer cancel()
+
+	_, err := fs.client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:             aws.String(fs.bucket),
+		Key:                aws.String(key),
+		CopySource:         aws.String(copySource(fs.bucket, key)),
+		CopySourceIfMatch:  head.ETag,
+		MetadataDirective:  types.MetadataDirectiveReplace,
+		Metadata:           meta,
+		CacheControl:       head.CacheControl,
+		ContentDisposition: head.ContentDisposition,
+		ContentEncoding:    head.ContentEncoding,
+		ContentLanguage:    head.ContentLanguage,
+		ContentType:        head.ContentType,
+		StorageClass:       head.StorageClass,
+	})
+	return mapError(err)
+}
+
+// multipartCopy copies the object src of the given size to dst with
+// UploadPartCopy requests. The copy gets the user metadata meta, or keeps
+// the metadata of src if meta is nil.
+func (fs *S3FS) multipartCopy(src, dst string, size int64, meta map[string]string) error {
+	head, err := fs.headObject(src)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		meta = head.Metadata
+	}
+
+	create, err := fs.client.CreateMultipartUpload(fs.ctx, &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(fs.bucket),
+		Key:          aws.String(dst),
+		ContentType:  head.ContentType,
+		Metadata:     meta,
+		StorageClass: head.StorageClass,
+	})
+	if err != nil {
+		return mapError(err)
+	}
+
+	partSize := partSizeOf(size)
+	var parts []types.CompletedPart
+	for off, num := int64(0), int32(1); off < size; off, num = off+partSize, num+1 {
+		end := min(off+partSize, size) - 1
+		out, err := fs.client.UploadPartCopy(fs.ctx, &s3.UploadPartCopyInput{
+			Bucket:            aws.String(fs.bucket),
+			Key:               aws.String(dst),
+			UploadId:          create.UploadId,
+			PartNumber:        aws.Int32(num),
+			CopySource:        aws.String(copySource(fs.bucket, src)),
+			CopySourceRange:   aws.String(httpRange(off, end)),
+			CopySourceIfMatch: head.ETag,
+		})
+		if err != nil {
+			fs.abortUpload(dst, create.UploadId)
+			return mapError(err)
+		}
+		parts = append(parts, types.CompletedPart{
+			ETag:       out.CopyPartResult.ETag,
+			PartNumber: aws.Int32(num),
+		})
+	}
+
+	_, err = fs.client.CompleteMultipartUpload(fs.ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(fs.bucket),
+		Key:             aws.String(dst),
+		UploadId:        create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		fs.abortUpload(dst, create.UploadId)
+		return mapError(err)
+	}
+	return nil
+}
+
+// partSizeOf returns the part size of a multipart copy of an object of the
+// given size, which must fit in maxUploadParts parts.
+func partSizeOf(size int64) int64 {
+	return max(copyPartSize, (size+maxUploadParts-1)/maxUploadParts)
+}
+
+// abortUpload discards the parts of a multipart upload.
+func (fs *S3FS) abortUpload(key string, uploadID *string) {
+	// best effort, a lifecycle rule has to clean up on failure
+	// the upload is also aborted when the context of fs is cancelled
+	ctx, cancel := fs.opts.withTimeout(context.WithoutCancel(fs.ctx))
+	defer cancel()
+
+	_, _ = fs.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(fs.bucket),
+		Key:      aws.String(key),
+		UploadId: uploadID,
+	})
+}
+
+// copySource formats the CopySource of a copy request for the object key
+// in bucket, escaping every path segment of the key.
+func copySource(bucket, key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return bucket + "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,38 @@
+package s3fs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopySource(t *testing.T) {
+	tests := []struct {
+		name   string
+		bucket string
+		key    string
+		want   string
+	}{
+		{"plain key", "bucket", "dir/file.txt", "bucket/dir/file.txt"},
+		{"leading slash", "bucket", "/dir/file.txt", "bucket//dir/file.txt"},
+		{"spaces", "bucket", "my dir/my file", "bucket/my%20dir/my%20file"},
+		{"special characters", "bucket", "a?b#c/d%e", "bucket/a%3Fb%23c/d%25e"},
+		{"unicode", "bucket", "файл", "bucket/%D1%84%D0%B0%D0%B9%D0%BB"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, copySource(tt.bucket, tt.key))
+		})
+	}
+}
+
+func TestPartSizeOf(t *testing.T) {
+	for _, size := range []int64{maxCopyObjectSize + 1, 4 << 40, 5000 << 30, 5 << 40} {
+		partSize := partSizeOf(size)
+		assert.GreaterOrEqual(t, partSize, int64(copyPartSize), "size %d", size)
+		assert.LessOrEqual(t, partSize, int64(maxPartSize), "size %d", size)
+		assert.LessOrEqual(t, (size+partSize-1)/partSize, int64(maxUploadParts), "size %d", size)
+	}
+	assert.Equal(t, int64(copyPartSize), partSizeOf(maxCopyObjectSize+1))
+}
@@ -3,6 +3,19 @@
 // integration with applications relying on the billy abstraction, supporting
 // operations like reading, writing, deleting, and traversing files and dirs.
 //
+// # Errors
+//
+// Operations fail with a *os.PathError, or a *os.LinkError for Rename and
+// Symlink. Errors returned by S3 are classified as fs.ErrNotExist,
+// fs.ErrPermission, fs.ErrExist, ErrPreconditionFailed or ErrThrottled,
+// which errors.Is matches. The error of the S3 client, e.g. a
+// smithy.APIError, can be retrieved with errors.As, with one exception:
+// for fs.ErrNotExist, fs.ErrExist and fs.ErrPermission, the Err of the
+// path error is the bare sentinel, since os.IsNotExist, os.IsExist and
+// os.IsPermission, which billy and go-git rely on, match nothing else. The
+// error of the S3 client is then passed to the hook set with WithErrorHook
+// instead.
+//
 // Note: When working with paths in this package, always use the `path` package
 // instead of `filepath`, as S3 paths are UNIX-like and must use forward
 // slashes (/).
@@ -1,8 +1,145 @@
 package s3fs
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go"
+)
 
 var (
 	ErrLockNotSupported = errors.New("locking is not supported")
+	ErrLockLost         = errors.New("lock lost")
 	ErrNotImplemented   = errors.New("not implemented")
+
+	// ErrPreconditionFailed is returned when a conditional request fails
+	// because the object has been changed or created concurrently.
+	ErrPreconditionFailed = errors.New("precondition failed")
+
+	// ErrThrottled is returned when S3 rejects a request because of its
+	// request rate, after the retries of the S3 client are exhausted. The
+	// operation can be retried later.
+	ErrThrottled = errors.New("request throttled")
 )
+
+// s3Error is an error returned by S3, classified as one of the sentinel
+// errors of the fs package or of this package. Both the sentinel and the
+// original error can be matched with errors.Is and errors.As, except in
+// the errors reported by operations for the sentinels of the os package
+// (see osError).
+type s3Error struct {
+	kind error
+	err  error
+}
+
+func (e *s3Error) Error() string {
+	return e.err.Error()
+}
+
+func (e *s3Error) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// mapError translates an error returned by the S3 client into an s3Error,
+// based on the error code of the response or, for responses without body
+// such as those of HeadObject, on its HTTP status. Errors that can't be
+// classified, including the cancellation of the context, are returned
+// unchanged.
+func mapError(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	var s3Err *s3Error
+	if errors.As(err, &s3Err) {
+		return err
+	}
+
+	var kind error
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		kind = codeKind(apiErr.ErrorCode())
+	}
+	var respErr *awshttp.ResponseError
+	if kind == nil && errors.As(err, &respErr) {
+		kind = statusKind(respErr.HTTPStatusCode())
+	}
+	if kind == nil {
+		return err
+	}
+	return &s3Error{kind: kind, err: err}
+}
+
+// codeKind returns the sentinel error of an S3 error code, or nil.
+func codeKind(code string) error {
+	switch code {
+	case "NoSuchKey", "NotFound", "NoSuchBucket", "NoSuchUpload":
+		return fs.ErrNotExist
+	case "AccessDenied", "Forbidden", "AllAccessDisabled", "InvalidAccessKeyId",
+		"SignatureDoesNotMatch", "InvalidObjectState":
+		return fs.ErrPermission
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return ErrPreconditionFailed
+	case "SlowDown", "Throttling", "ThrottlingException", "RequestLimitExceeded",
+		"TooManyRequests", "TooManyRequestsException", "RequestThrottled":
+		return ErrThrottled
+	}
+	return nil
+}
+
+// statusKind returns the sentinel error of an HTTP status, or nil.
+func statusKind(status int) error {
+	switch status {
+	case http.StatusNotFound:
+		return fs.ErrNotExist
+	case http.StatusForbidden:
+		return fs.ErrPermission
+	case http.StatusPreconditionFailed:
+		return ErrPreconditionFailed
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return ErrThrottled
+	}
+	return nil
+}
+
+// existError translates the failure of a conditional create, e.g. a
+// PutObject with If-None-Match, into fs.ErrExist.
+func existError(err error) error {
+	if errors.Is(err, ErrPreconditionFailed) {
+		return &s3Error{kind: fs.ErrExist, err: err}
+	}
+	return err
+}
+
+// osError returns the error reported by the operation op on path for err.
+//
+// os.IsNotExist, os.IsExist and os.IsPermission, which go-git relies on,
+// don't use errors.Is: they only match their sentinel, or a syscall.Errno,
+// as the Err of a *os.PathError or *os.LinkError. An S3 error classified
+// as one of these sentinels is replaced with it, much like the os package
+// reports a bare errno, and passed to the hook set with WithErrorHook. The
+// error of the S3 client is then not reachable with errors.As, see the
+// package documentation.
+func (fs *S3FS) osError(op, path string, err error) error {
+	var s3Err *s3Error
+	if !errors.As(err, &s3Err) {
+		return err
+	}
+	switch s3Err.kind {
+	case os.ErrNotExist, os.ErrExist, os.ErrPermission:
+		if fs.opts.errorHook != nil {
+			fs.opts.errorHook(op, path, err)
+		}
+		return s3Err.kind
+	}
+	return err
+}
+
+// pathError returns the *os.PathError of the operation op on path failing
+// with err, see osError.
+func (fs *S3FS) pathError(op, path string, err error) error {
+	return &os.PathError{Op: op, Path: path, Err: fs.osError(op, path, err)}
+}
@@ -0,0 +1,181 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"testing"
+
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/gurza/go-billy-s3fs/s3fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// responseError returns the error of the S3 client for a response with
+// the given status and error code.
+func responseError(status int, code string) error {
+	return &smithy.OperationError{
+		ServiceID:     "S3",
+		OperationName: "HeadObject",
+		Err: &awshttp.ResponseError{
+			ResponseError: &smithyhttp.ResponseError{
+				Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+				Err:      &smithy.GenericAPIError{Code: code},
+			},
+		},
+	}
+}
+
+func TestMapError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"no such key", &types.NoSuchKey{}, fs.ErrNotExist},
+		{"not found", &types.NotFound{}, fs.ErrNotExist},
+		{"no such upload", &types.NoSuchUpload{}, fs.ErrNotExist},
+		{"access denied", &smithy.GenericAPIError{Code: "AccessDenied"}, fs.ErrPermission},
+		{"precondition failed", &smithy.GenericAPIError{Code: "PreconditionFailed"}, ErrPreconditionFailed},
+		{"conditional request conflict", &smithy.GenericAPIError{Code: "ConditionalRequestConflict"}, ErrPreconditionFailed},
+		{"slow down", &smithy.GenericAPIError{Code: "SlowDown"}, ErrThrottled},
+		{"wrapped", fmt.Errorf("put: %w", &smithy.GenericAPIError{Code: "NoSuchKey"}), fs.ErrNotExist},
+		{"code of response", responseError(http.StatusBadRequest, "AccessDenied"), fs.ErrPermission},
+		{"status without code", responseError(http.StatusNotFound, ""), fs.ErrNotExist},
+		{"status forbidden", responseError(http.StatusForbidden, ""), fs.ErrPermission},
+		{"status precondition failed", responseError(http.StatusPreconditionFailed, ""), ErrPreconditionFailed},
+		{"status service unavailable", responseError(http.StatusServiceUnavailable, ""), ErrThrottled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := mapError(tt.err)
+			assert.ErrorIs(t, err, tt.want)
+			assert.Equal(t, tt.err.Error(), err.Error())
+
+			// the original error is preserved
+			var apiErr smithy.APIError
+			assert.True(t, errors.As(err, &apiErr) == errors.As(tt.err, &apiErr))
+			assert.ErrorIs(t, err, tt.err)
+
+			// mapping is idempotent
+			assert.Equal(t, err, mapError(err))
+		})
+	}
+}
+
+func TestMapError_Unchanged(t *testing.T) {
+	for _, err := range []error{
+		nil,
+		errors.New("NoSuchKey"),
+		&smithy.GenericAPIError{Code: "InternalError"},
+		responseError(http.StatusInternalServerError, "InternalError"),
+		fmt.Errorf("get: %w", context.Canceled),
+		&smithy.OperationError{Err: context.DeadlineExceeded},
+	} {
+		assert.Equal(t, err, mapError(err))
+	}
+}
+
+func TestExistError(t *testing.T) {
+	err := existError(mapError(&smithy.GenericAPIError{Code: "PreconditionFailed"}))
+	assert.ErrorIs(t, err, fs.ErrExist)
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+
+	err = existError(mapError(&smithy.GenericAPIError{Code: "AccessDenied"}))
+	assert.NotErrorIs(t, err, fs.ErrExist)
+	assert.ErrorIs(t, err, fs.ErrPermission)
+
+	assert.NoError(t, existError(nil))
+}
+
+func TestS3FS_OSErrors(t *testing.T) {
+	fsys, _ := newTestFS(t)
+	require.NoError(t, util.WriteFile(fsys, "file", []byte("data"), 0644))
+
+	// go-git checks errors with os.IsNotExist and os.IsExist, which don't
+	// use errors.Is
+	_, err := fsys.Open("missing")
+	assert.True(t, os.IsNotExist(err), "%v", err)
+	_, err = fsys.OpenFile("missing", os.O_RDWR, 0)
+	assert.True(t, os.IsNotExist(err), "%v", err)
+	_, err = fsys.OpenFile("missing", os.O_RDWR|os.O_TRUNC, 0)
+	assert.True(t, os.IsNotExist(err), "%v", err)
+	_, err = fsys.Stat("missing")
+	assert.True(t, os.IsNotExist(err), "%v", err)
+	_, err = fsys.Readlink("missing")
+	assert.True(t, os.IsNotExist(err), "%v", err)
+	assert.True(t, os.IsNotExist(fsys.Remove("missing")))
+	assert.True(t, os.IsNotExist(fsys.Rename("missing", "other")))
+	assert.True(t, os.IsNotExist(fsys.Chmod("missing", 0600)))
+
+	_, err = fsys.OpenFile("file", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	assert.True(t, os.IsExist(err), "%v", err)
+
+	// exclusive writers racing, buffered and streamed: the second to close
+	// loses
+	for _, flag := range []int{os.O_RDWR, os.O_WRONLY} {
+		name := fmt.Sprintf("new-%d", flag)
+		first, err := fsys.OpenFile(name, flag|os.O_CREATE|os.O_EXCL, 0644)
+		require.NoError(t, err)
+		second, err := fsys.OpenFile(name, flag|os.O_CREATE|os.O_EXCL, 0644)
+		require.NoError(t, err)
+		require.NoError(t, first.Close())
+		err = second.Close()
+		assert.True(t, os.IsExist(err), "%v", err)
+	}
+}
+
+// headErrorClient is an S3API failing every HeadObject request with err.
+type headErrorClient struct {
+	S3API
+	err error
+}
+
+func (c headErrorClient) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return nil, c.err
+}
+
+func TestWithErrorHook(t *testing.T) {
+	var hooked []error
+	hook := WithErrorHook(func(op, path string, err error) {
+		assert.Equal(t, "stat", op)
+		assert.Equal(t, "file", path)
+		hooked = append(hooked, err)
+	})
+	denied := headErrorClient{s3fstest.NewClient("bucket"), responseError(http.StatusForbidden, "AccessDenied")}
+	fsys, err := New(denied, "bucket", hook)
+	require.NoError(t, err)
+
+	// the bare sentinel is reported, without the error of the S3 client,
+	// which only reaches the hook
+	_, err = fsys.Stat("file")
+	assert.True(t, os.IsPermission(err), "%v", err)
+	var apiErr smithy.APIError
+	assert.False(t, errors.As(err, &apiErr))
+	require.Len(t, hooked, 1)
+	require.ErrorAs(t, hooked[0], &apiErr)
+	assert.Equal(t, "AccessDenied", apiErr.ErrorCode())
+
+	// other S3 errors are reported as they are
+	hooked = nil
+	throttled := headErrorClient{s3fstest.NewClient("bucket"), responseError(http.StatusServiceUnavailable, "SlowDown")}
+	fsys, err = New(throttled, "bucket", hook)
+	require.NoError(t, err)
+	_, err = fsys.Stat("file")
+	var pathErr *os.PathError
+	assert.ErrorAs(t, err, &pathErr)
+	assert.ErrorIs(t, err, ErrThrottled)
+	require.ErrorAs(t, err, &apiErr)
+	assert.Equal(t, "SlowDown", apiErr.ErrorCode())
+	assert.Empty(t, hooked)
+}
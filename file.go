@@ -1,35 +1,62 @@
 package s3fs
 
 import (
-	"bytes"
+	"errors"
 	"io"
 	"os"
-
-	"github.com/go-git/go-billy/v5"
 )
 
+// file is an in-memory buffered billy.File. The object content is held in
+// memory while the file is open and, for writable files, uploaded to S3
+// when the file is closed.
 type file struct {
-	reader  *bytes.Reader
-	content []byte
+	fs      *S3FS
+	key     string
 	name    string
+	flag    int
+	content []byte
+	meta    map[string]string // user metadata of the uploaded object
+	pos     int64
+	dirty   bool // content must be uploaded on Close
+	closed  bool
+	lock    fileLock
 }
 
-func newFile(name string, b []byte) (billy.File, error) {
-	// use an in-memory reader to wrap the content
-	rdr := bytes.NewReader(b)
-
+func newFile(fs *S3FS, key, name string, flag int, b []byte, meta map[string]string) *file {
 	return &file{
-		reader:  rdr,
-		content: b,
+		fs:      fs,
+		key:     key,
 		name:    name,
-	}, nil
+		flag:    flag,
+		content: b,
+		meta:    meta,
+	}
 }
 
 func (f *file) Read(b []byte) (int, error) {
-	return f.reader.Read(b)
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if !isReadable(f.flag) {
+		return 0, os.ErrPermission
+	}
+
+	if f.pos >= int64(len(f.content)) {
+		return 0, io.EOF
+	}
+	n := copy(b, f.content[f.pos:])
+	f.pos += int64(n)
+	return n, nil
 }
 
 func (f *file) ReadAt(b []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if !isReadable(f.flag) {
+		return 0, os.ErrPermission
+	}
+
 	if off < 0 || off >= int64(len(f.content)) {
 		return 0, io.EOF
 	}
@@ -44,33 +71,86 @@ func (f *file) ReadAt(b []byte, off int64) (int, error) {
 }
 
 func (f *file) Write(b []byte) (int, error) {
-	f.content = append(f.content, b...)
-	f.resetReader()
-	return len(b), nil
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if !isWritable(f.flag) {
+		return 0, os.ErrPermission
+	}
+
+	if f.flag&os.O_APPEND != 0 {
+		f.pos = int64(len(f.content))
+	}
+	end := f.pos + int64(len(b))
+	if end > int64(len(f.content)) {
+		f.grow(end)
+	}
+	n := copy(f.content[f.pos:], b)
+	f.pos = end
+	f.dirty = true
+	return n, nil
 }
 
 func (f *file) Truncate(size int64) error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	if !isWritable(f.flag) {
+		return os.ErrPermission
+	}
 	if size < 0 {
 		return os.ErrInvalid
 	}
 
 	if size > int64(len(f.content)) {
-		padding := make([]byte, size-int64(len(f.content)))
-		f.content = append(f.content, padding...)
+		f.grow(size)
 	} else {
 		f.content = f.content[:size]
 	}
 
-	f.resetReader()
+	f.dirty = true
 	return nil
 }
 
 func (f *file) Seek(offset int64, whence int) (int64, error) {
-	return f.reader.Seek(offset, whence)
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.pos + offset
+	case io.SeekEnd:
+		abs = int64(len(f.content)) + offset
+	default:
+		return 0, errors.New("s3fs.file.Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("s3fs.file.Seek: negative position")
+	}
+
+	f.pos = abs
+	return abs, nil
 }
 
+// Close uploads the content of a modified writable file to S3.
 func (f *file) Close() error {
-	return nil // no actual file to close
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	defer f.lock.release(f.fs)
+
+	if !f.dirty {
+		return nil
+	}
+	if err := f.fs.writeObject(f.key, f.content, f.meta, isExclusive(f.flag)); err != nil {
+		return f.fs.pathError("close", f.name, err)
+	}
+	return nil
 }
 
 func (f *file) Name() string {
@@ -82,13 +162,15 @@ func (f *file) Size() int64 {
 }
 
 func (f *file) Lock() error {
-	return ErrLockNotSupported
+	return f.lock.lock(f.fs, f.key)
 }
 
 func (f *file) Unlock() error {
-	return ErrLockNotSupported
+	return f.lock.unlock(f.fs)
 }
 
-func (f *file) resetReader() {
-	f.reader = bytes.NewReader(f.content)
+// grow extends the content with zero bytes up to size.
+func (f *file) grow(size int64) {
+	padding := make([]byte, size-int64(len(f.content)))
+	f.content = append(f.content, padding...)
 }
@@ -1,10 +1,16 @@
 package s3fs
 
 import (
+	"bytes"
+	"io"
+	"os"
 	"reflect"
+	"syscall"
 	"testing"
 
 	"github.com/go-git/go-billy/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestS3FS_ImplementsBillyFile(t *testing.T) {
@@ -18,3 +24,203 @@ func TestS3FS_ImplementsBillyFile(t *testing.T) {
 		t.Errorf("file does not implement billy.File interface")
 	}
 }
+
+func TestRangeFile_SeekBeyondEnd(t *testing.T) {
+	f := newRangeFile(nil, "key", "name", nil, 10)
+
+	// nothing is requested when the offset is at or past the end
+	pos, err := f.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), pos)
+	_, err = f.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+	_, err = f.ReadAt(make([]byte, 1), 12)
+	assert.ErrorIs(t, err, io.EOF)
+
+	_, err = f.Seek(-11, io.SeekCurrent)
+	assert.Error(t, err)
+
+	_, err = f.Write([]byte("x"))
+	assert.ErrorIs(t, err, os.ErrPermission)
+	assert.NoError(t, f.Close())
+	assert.ErrorIs(t, f.Close(), os.ErrClosed)
+}
+
+func TestHTTPRange(t *testing.T) {
+	assert.Equal(t, "bytes=0-", httpRange(0, -1))
+	assert.Equal(t, "bytes=10-", httpRange(10, -1))
+	assert.Equal(t, "bytes=0-0", httpRange(0, 0))
+	assert.Equal(t, "bytes=5-9", httpRange(5, 9))
+}
+
+func TestUploadFile_SeekTruncate(t *testing.T) {
+	f := newUploadFile(&S3FS{opts: defaultOptions()}, "key", "name", os.O_WRONLY|os.O_TRUNC, nil)
+
+	// small writes stay buffered until Close
+	n, err := f.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+	assert.Nil(t, f.uploadID)
+
+	pos, err := f.Seek(0, io.SeekCurrent)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), pos)
+	_, err = f.Seek(0, io.SeekStart)
+	assert.ErrorIs(t, err, billy.ErrNotSupported)
+
+	assert.NoError(t, f.Truncate(5))
+	assert.ErrorIs(t, f.Truncate(0), billy.ErrNotSupported)
+
+	_, err = f.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrPermission)
+}
+
+func TestFile_ReadWriteSeek(t *testing.T) {
+	f := newFile(nil, "key", "name", os.O_RDWR, []byte("hello"), nil)
+
+	n, err := f.Write([]byte("HE"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	pos, err := f.Seek(0, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), pos)
+
+	_, err = f.Write([]byte(" world"))
+	assert.NoError(t, err)
+
+	_, err = f.Seek(0, io.SeekStart)
+	assert.NoError(t, err)
+	b, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "HEllo world", string(b))
+	assert.True(t, f.dirty)
+
+	_, err = f.Seek(-1, io.SeekStart)
+	assert.Error(t, err)
+}
+
+func TestFile_WriteBeyondEnd(t *testing.T) {
+	f := newFile(nil, "key", "name", os.O_WRONLY, nil, nil)
+
+	_, err := f.Seek(3, io.SeekStart)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("x"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0, 0, 0, 'x'}, f.content)
+
+	_, err = f.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrPermission)
+}
+
+func TestFile_Append(t *testing.T) {
+	f := newFile(nil, "key", "name", os.O_RDWR|os.O_APPEND, []byte("abc"), nil)
+
+	_, err := f.Write([]byte("def"))
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdef", string(f.content))
+}
+
+func TestFile_Truncate(t *testing.T) {
+	f := newFile(nil, "key", "name", os.O_RDWR, []byte("abcdef"), nil)
+
+	assert.NoError(t, f.Truncate(3))
+	assert.Equal(t, "abc", string(f.content))
+	assert.NoError(t, f.Truncate(5))
+	assert.Equal(t, []byte{'a', 'b', 'c', 0, 0}, f.content)
+	assert.ErrorIs(t, f.Truncate(-1), os.ErrInvalid)
+
+	ro := newFile(nil, "key", "name", os.O_RDONLY, []byte("abc"), nil)
+	assert.ErrorIs(t, ro.Truncate(0), os.ErrPermission)
+	_, err := ro.Write([]byte("x"))
+	assert.ErrorIs(t, err, os.ErrPermission)
+}
+
+func TestFile_CloseUnmodified(t *testing.T) {
+	f := newFile(nil, "key", "name", os.O_RDWR, []byte("abc"), nil)
+
+	// nothing was written, so nothing is uploaded
+	assert.NoError(t, f.Close())
+	assert.ErrorIs(t, f.Close(), os.ErrClosed)
+	_, err := f.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrClosed)
+}
+
+func TestUploadFile_Multipart(t *testing.T) {
+	fsys, client := newTestFS(t, WithPartSize(minPartSize), WithUploadConcurrency(2))
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*minPartSize+1024)/16)
+	f, err := fsys.OpenFile("big", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, 0, client.Uploads("bucket"))
+
+	f, err = fsys.Open("big")
+	require.NoError(t, err)
+	defer f.Close()
+	fi, err := fsys.Stat("big")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), fi.Size())
+
+	// reads across the boundary of the parts
+	buf := make([]byte, 32)
+	_, err = f.ReadAt(buf, minPartSize-16)
+	require.NoError(t, err)
+	assert.Equal(t, data[minPartSize-16:minPartSize+16], buf)
+
+	_, err = f.Seek(-8, io.SeekEnd)
+	require.NoError(t, err)
+	tail, err := io.ReadAll(f)
+	require.NoError(t, err)
+	assert.Equal(t, data[len(data)-8:], tail)
+}
+
+func TestUploadFile_Buffer(t *testing.T) {
+	fsys, _ := newTestFS(t, WithPartSize(minPartSize))
+
+	f, err := fsys.OpenFile("file", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	require.NoError(t, err)
+	uf := f.(*uploadFile)
+
+	// the buffer grows with the data, up to a part
+	_, err = f.Write([]byte("small"))
+	require.NoError(t, err)
+	assert.Less(t, cap(uf.buf), 1024)
+	chunk := make([]byte, 1<<20)
+	for i := 0; i < 4; i++ {
+		_, err = f.Write(chunk)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, cap(uf.buf), minPartSize)
+	}
+	_, err = f.Write(chunk)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), uf.partNum)
+	assert.Len(t, uf.buf, len("small"))
+	require.NoError(t, f.Close())
+
+	fi, err := fsys.Stat("file")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("small")+5*len(chunk)), fi.Size())
+}
+
+func TestUploadFile_TooManyParts(t *testing.T) {
+	fsys, client := newTestFS(t, WithPartSize(minPartSize))
+
+	f, err := fsys.OpenFile("big", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(make([]byte, minPartSize))
+	require.NoError(t, err)
+
+	// as if the last part S3 accepts had just been sent
+	f.(*uploadFile).partNum = maxUploadParts
+	n, err := f.Write([]byte("more"))
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, syscall.EFBIG)
+
+	assert.ErrorIs(t, f.Close(), syscall.EFBIG)
+	assert.Equal(t, 0, client.Uploads("bucket"))
+	_, err = fsys.Stat("big")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,277 @@
+package s3fs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// DefaultLockLease is the default lease duration of file locks. See
+// WithLockLease.
+const DefaultLockLease = 30 * time.Second
+
+// LockDir is the directory, at the root of the key prefix of the
+// filesystem (see WithPrefix), where the lock objects of files are kept.
+// It is hidden from the filesystem: ReadDir doesn't list it, and every
+// path in it fails with os.ErrPermission. Lock objects are not kept as
+// "<name>.lock" next to their files, since go-git writes such files
+// itself, and stale locks would show up in every listing.
+const LockDir = ".locks"
+
+// lockRetryInterval is the time Lock waits before trying again to acquire
+// a lock held by someone else.
+const lockRetryInterval = 250 * time.Millisecond
+
+// User metadata keys of a lock object.
+const (
+	metaLockOwner   = "lock-owner"
+	metaLockExpires = "lock-expires"
+)
+
+// fileLock is the state of the advisory lock of an open file.
+//
+// A lock is an empty object in LockDir (see lockKey), created with a
+// conditional PutObject so that only one owner can hold it. The object
+// carries the ID of its owner and the expiry of its lease, which a
+// background goroutine extends while the lock is held. A lock whose lease
+// has expired, e.g. because its owner crashed, is taken over by the next
+// Lock. Expiries are compared with the local clock, so the clocks of the
+// lock users must not drift apart by more than a fraction of the lease
+// duration. An object that isn't a lock is never taken over.
+type fileLock struct {
+	mu   sync.Mutex
+	held *lease
+}
+
+// lock acquires the lock of the object key, blocking until it is
+// available.
+func (l *fileLock) lock(fs *S3FS, key string) error {
+	if fs.opts.readOnly {
+		return ErrLockNotSupported
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held != nil {
+		// like flock, locking an already locked file succeeds
+		return nil
+	}
+
+	le, err := fs.acquireLease(fs.lockKey(key))
+	if err != nil {
+		return err
+	}
+	l.held = le
+	return nil
+}
+
+// unlock releases the lock, if it is held. It fails with ErrLockLost if
+// the lease could not be renewed in time and the lock may have been taken
+// over.
+func (l *fileLock) unlock(fs *S3FS) error {
+	if fs.opts.readOnly {
+		return ErrLockNotSupported
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		return nil
+	}
+
+	le := l.held
+	l.held = nil
+	return fs.releaseLease(le)
+}
+
+// release releases the lock when the file is closed, if it is held.
+func (l *fileLock) release(fs *S3FS) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held != nil {
+		// best effort, the lease expires anyway
+		_ = fs.releaseLease(l.held)
+		l.held = nil
+	}
+}
+
+// lockDir returns the key of LockDir. It is relative to the key prefix
+// rather than to the root, so that a file has the same lock in every
+// filesystem returned by Chroot.
+func (fs *S3FS) lockDir() string {
+	return joinKey(vfsKey(fs.opts.prefix), LockDir)
+}
+
+// lockKey returns the key of the lock object of the object key: the lock
+// of "a/b" is ".locks/a/b".
+func (fs *S3FS) lockKey(key string) string {
+	return joinKey(fs.lockDir(), relKey(vfsKey(fs.opts.prefix), key))
+}
+
+// lease is a held lock.
+type lease struct {
+	key   string
+	owner string
+	stop  chan struct{}
+	done  chan struct{}
+
+	mu   sync.Mutex // guards etag and err
+	etag *string
+	err  error
+}
+
+// acquireLease creates the lock object key, or takes it over once its
+// lease has expired, and starts renewing it.
+func (fs *S3FS) acquireLease(key string) (*lease, error) {
+	le := &lease{
+		key:   key,
+		owner: getRandom(),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	for {
+		etag, err := fs.putLease(le, aws.String("*"), nil)
+		if err == nil {
+			le.etag = etag
+			break
+		}
+		if !errors.Is(err, ErrPreconditionFailed) {
+			return nil, err
+		}
+
+		// held by someone else, unless the lease has expired
+		output, err := fs.headObject(key)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !isLease(output.Metadata) {
+			// never overwrite what isn't a lock object
+			return nil, fmt.Errorf("%s is not a lock object: %w", key, os.ErrExist)
+		}
+		if expires := timeFromMeta(output.Metadata, metaLockExpires, time.Time{}); time.Now().After(expires) {
+			etag, err := fs.putLease(le, nil, output.ETag)
+			if err == nil {
+				le.etag = etag
+				break
+			}
+			if !errors.Is(err, ErrPreconditionFailed) {
+				return nil, err
+			}
+			// taken over by someone else first
+			continue
+		}
+
+		time.Sleep(lockRetryInterval)
+	}
+
+	go fs.renewLease(le)
+	return le, nil
+}
+
+// putLease writes the lock object of le with a new expiry, if the object
+// matches the conditions ifNoneMatch and ifMatch, and returns its ETag.
+func (fs *S3FS) putLease(le *lease, ifNoneMatch, ifMatch *string) (*string, error) {
+	ctx, cancel := fs.opts.withTimeout(fs.ctx)
+	defer cancel()
+
+	output, err := fs.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(fs.bucket),
+		Key:           aws.String(le.key),
+		Body:          strings.NewReader(""),
+		ContentLength: aws.Int64(0),
+		Metadata: map[string]string{
+			metaLockOwner:   le.owner,
+			metaLockExpires: formatTime(time.Now().Add(fs.opts.lockLease)),
+		},
+		IfNoneMatch: ifNoneMatch,
+		IfMatch:     ifMatch,
+	})
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return output.ETag, nil
+}
+
+// renewLease extends the lease of le periodically until it is released or
+// lost. The lease is lost if the lock object has been changed by someone
+// else, or if it could not be renewed before it expired.
+func (fs *S3FS) renewLease(le *lease) {
+	defer close(le.done)
+
+	ticker := time.NewTicker(fs.opts.lockLease / 3)
+	defer ticker.Stop()
+
+	expires := time.Now().Add(fs.opts.lockLease)
+	for {
+		select {
+		case <-le.stop:
+			return
+		case <-ticker.C:
+		}
+
+		le.mu.Lock()
+		etag, err := fs.putLease(le, nil, le.etag)
+		switch {
+		case err == nil:
+			le.etag = etag
+			expires = time.Now().Add(fs.opts.lockLease)
+		case isLost(err) || time.Now().After(expires):
+			le.err = ErrLockLost
+		}
+		lost := le.err != nil
+		le.mu.Unlock()
+		if lost {
+			return
+		}
+	}
+}
+
+// releaseLease stops renewing le and deletes its lock object, unless it
+// has been taken over.
+func (fs *S3FS) releaseLease(le *lease) error {
+	close(le.stop)
+	<-le.done
+
+	le.mu.Lock()
+	defer le.mu.Unlock()
+	if le.err != nil {
+		return le.err
+	}
+
+	ctx, cancel := fs.opts.withTimeout(fs.ctx)
+	defer cancel()
+
+	_, err := fs.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:  aws.String(fs.bucket),
+		Key:     aws.String(le.key),
+		IfMatch: le.etag,
+	})
+	err = mapError(err)
+	if isLost(err) {
+		return ErrLockLost
+	}
+	return err
+}
+
+// isLease reports whether meta is the user metadata of a lock object.
+func isLease(meta map[string]string) bool {
+	_, owner := meta[metaLockOwner]
+	_, expires := meta[metaLockExpires]
+	return owner && expires
+}
+
+// isLost reports whether a conditional request on a lock object failed
+// because the object has been changed or deleted by someone else.
+func isLost(err error) bool {
+	return errors.Is(err, ErrPreconditionFailed) || errors.Is(err, os.ErrNotExist)
+}
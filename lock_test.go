@@ -0,0 +1,157 @@
+package s3fs
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile_Lock(t *testing.T) {
+	fsys, client := newTestFS(t, WithLockLease(time.Second))
+
+	f1, err := fsys.OpenFile("file", os.O_RDWR|os.O_CREATE, 0644)
+	require.NoError(t, err)
+	f2, err := fsys.OpenFile("file", os.O_RDWR|os.O_CREATE, 0644)
+	require.NoError(t, err)
+
+	require.NoError(t, f1.Lock())
+	require.NoError(t, f1.Lock())
+	assert.Contains(t, client.Keys("bucket"), ".locks/file")
+
+	locked := make(chan error)
+	go func() { locked <- f2.Lock() }()
+	select {
+	case <-locked:
+		t.Fatal("lock acquired twice")
+	case <-time.After(2 * time.Second):
+		// held beyond its first lease, which is renewed
+	}
+
+	require.NoError(t, f1.Unlock())
+	require.NoError(t, <-locked)
+	require.NoError(t, f1.Unlock())
+
+	// closing a file releases its lock
+	require.NoError(t, f2.Close())
+	assert.NotContains(t, client.Keys("bucket"), ".locks/file")
+	require.NoError(t, f1.Close())
+}
+
+func TestFile_LockTakeover(t *testing.T) {
+	fsys, client := newTestFS(t, WithLockLease(300*time.Millisecond))
+
+	// a lease that is not renewed, as if its owner had crashed
+	stale, err := fsys.acquireLease(".locks/file")
+	require.NoError(t, err)
+	close(stale.stop)
+	<-stale.done
+
+	require.Contains(t, client.Keys("bucket"), ".locks/file")
+
+	le, err := fsys.acquireLease(".locks/file")
+	require.NoError(t, err)
+	assert.NotEqual(t, stale.owner, le.owner)
+	require.NoError(t, fsys.releaseLease(le))
+
+	// the stale owner finds out it has lost the lock
+	stale.stop = make(chan struct{})
+	assert.ErrorIs(t, fsys.releaseLease(stale), ErrLockLost)
+}
+
+func TestFile_LockKeepsFiles(t *testing.T) {
+	fsys, client := newTestFS(t, WithPrefix("repo"))
+
+	// go-git writes its own "*.lock" files, which locks leave alone
+	require.NoError(t, util.WriteFile(fsys, "HEAD", []byte("ref"), 0644))
+	require.NoError(t, util.WriteFile(fsys, "HEAD.lock", []byte("precious"), 0644))
+	f, err := fsys.Open("HEAD")
+	require.NoError(t, err)
+	require.NoError(t, f.Lock())
+	assert.Contains(t, client.Keys("bucket"), "repo/.locks/HEAD")
+	require.NoError(t, f.Unlock())
+	b, err := util.ReadFile(fsys, "HEAD.lock")
+	require.NoError(t, err)
+	assert.Equal(t, "precious", string(b))
+
+	// a chroot shares the locks of its parent
+	require.NoError(t, util.WriteFile(fsys, "dir/file", nil, 0644))
+	sub, err := fsys.Chroot("dir")
+	require.NoError(t, err)
+	f, err = sub.Open("file")
+	require.NoError(t, err)
+	require.NoError(t, f.Lock())
+	assert.Contains(t, client.Keys("bucket"), "repo/.locks/dir/file")
+	require.NoError(t, f.Close())
+
+	// an object in the place of a lock, put by another tool, is not taken
+	// over
+	_, err = client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("repo/.locks/HEAD"),
+		Body:   strings.NewReader("precious"),
+	})
+	require.NoError(t, err)
+	f, err = fsys.Open("HEAD")
+	require.NoError(t, err)
+	assert.ErrorIs(t, f.Lock(), os.ErrExist)
+	require.NoError(t, f.Close())
+	out, err := client.GetObject(context.Background(), &s3.GetObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("repo/.locks/HEAD"),
+	})
+	require.NoError(t, err)
+	b, err = io.ReadAll(out.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "precious", string(b))
+}
+
+func TestFile_LockDirHidden(t *testing.T) {
+	fsys, client := newTestFS(t)
+
+	require.NoError(t, util.WriteFile(fsys, "file", nil, 0644))
+	f, err := fsys.Open("file")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, f.Lock())
+	require.Contains(t, client.Keys("bucket"), ".locks/file")
+
+	infos, err := fsys.ReadDir("/")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "file", infos[0].Name())
+
+	// nothing in LockDir can be reached through the filesystem
+	_, err = fsys.Stat(".locks")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	_, err = fsys.Lstat("/.locks/file")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	_, err = fsys.Open(".locks/file")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	_, err = fsys.Create(".locks/other")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	assert.ErrorIs(t, fsys.Remove(".locks/file"), os.ErrPermission)
+	assert.ErrorIs(t, fsys.RemoveAll(".locks"), os.ErrPermission)
+	assert.ErrorIs(t, fsys.Rename("file", ".locks/file"), os.ErrPermission)
+	assert.ErrorIs(t, fsys.MkdirAll(".locks/dir", 0755), os.ErrPermission)
+	require.NoError(t, fsys.Symlink("/.locks", "lnk"))
+	_, err = fsys.Stat("lnk/file")
+	assert.ErrorIs(t, err, os.ErrPermission)
+	_, err = fsys.Chroot(".locks")
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	// in a chroot, ".locks" is an ordinary name
+	require.NoError(t, fsys.MkdirAll("dir", 0755))
+	sub, err := fsys.Chroot("dir")
+	require.NoError(t, err)
+	require.NoError(t, util.WriteFile(sub, ".locks/file", nil, 0644))
+	assert.Contains(t, client.Keys("bucket"), "dir/.locks/file")
+}
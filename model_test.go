@@ -0,0 +1,416 @@
+package s3fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/gurza/go-billy-s3fs/s3fstest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestS3FS_Model applies random sequences of operations to memfs and to
+// S3FS, and compares what they observe: results, error classes and the
+// final trees. A sequence on which they differ is shrunk to a minimal one.
+func TestS3FS_Model(t *testing.T) {
+	runs, length := 300, 40
+	if testing.Short() {
+		runs = 50
+	}
+	for seed := int64(1); seed <= int64(runs); seed++ {
+		ops := genModelOps(rand.New(rand.NewSource(seed)), length)
+		if diff := runModel(t, ops); diff != "" {
+			ops = shrinkModelOps(ops, func(ops []modelOp) bool { return runModel(t, ops) != "" })
+			var b strings.Builder
+			for _, op := range ops {
+				fmt.Fprintf(&b, "\t%s\n", op)
+			}
+			t.Fatalf("seed %d: memfs and S3FS differ after:\n%s%s", seed, b.String(), runModel(t, ops))
+		}
+	}
+}
+
+// The paths of the operations are few enough to collide, at several
+// depths, and are used for files and directories alike.
+var modelNames = []string{"a", "a/b", "d", "d/a", "d/e", "d/e/a", "f"}
+
+type modelOp struct {
+	kind   string
+	name   string
+	to     string // rename
+	data   []byte // write
+	offset int64  // seek, truncate
+	whence int    // seek
+}
+
+func (op modelOp) String() string {
+	switch op.kind {
+	case "write":
+		return fmt.Sprintf("write %q", op.data)
+	case "seek":
+		return fmt.Sprintf("seek %d %d", op.offset, op.whence)
+	case "truncate":
+		return fmt.Sprintf("truncate %d", op.offset)
+	case "rename":
+		return fmt.Sprintf("rename %s %s", op.name, op.to)
+	}
+	return op.kind + " " + op.name
+}
+
+func genModelOps(rnd *rand.Rand, n int) []modelOp {
+	name := func() string { return modelNames[rnd.Intn(len(modelNames))] }
+	ops := make([]modelOp, n)
+	for i := range ops {
+		switch k := rnd.Intn(10); k {
+		case 0, 1:
+			ops[i] = modelOp{kind: "create", name: name()}
+		case 2:
+			data := make([]byte, rnd.Intn(8))
+			for j := range data {
+				data[j] = byte('a' + rnd.Intn(26))
+			}
+			ops[i] = modelOp{kind: "write", data: data}
+		case 3:
+			ops[i] = modelOp{kind: "seek", offset: int64(rnd.Intn(14) - 4), whence: rnd.Intn(3)}
+		case 4:
+			ops[i] = modelOp{kind: "truncate", offset: int64(rnd.Intn(12))}
+		case 5:
+			ops[i] = modelOp{kind: "rename", name: name(), to: name()}
+		case 6:
+			ops[i] = modelOp{kind: "remove", name: name()}
+		case 7:
+			ops[i] = modelOp{kind: "mkdirall", name: name()}
+		case 8:
+			ops[i] = modelOp{kind: "readdir", name: name()}
+		case 9:
+			ops[i] = modelOp{kind: "stat", name: name()}
+		}
+	}
+	return ops
+}
+
+// shrinkModelOps removes operations from ops, in chunks of decreasing
+// size, as long as the sequence still fails.
+func shrinkModelOps(ops []modelOp, fails func([]modelOp) bool) []modelOp {
+	for n := len(ops) / 2; n >= 1; n /= 2 {
+		for i := 0; i+n <= len(ops); {
+			candidate := append(append([]modelOp{}, ops[:i]...), ops[i+n:]...)
+			if fails(candidate) {
+				ops = candidate
+			} else {
+				i += n
+			}
+		}
+	}
+	return ops
+}
+
+// runModel applies ops to memfs and to S3FS, and returns how they differ,
+// if they do.
+func runModel(t *testing.T, ops []modelOp) string {
+	fsys, err := New(s3fstest.NewClient("bucket"), "bucket")
+	require.NoError(t, err)
+	want, got := &modelRun{fs: memfs.New(), oracle: true}, &modelRun{fs: fsys}
+
+	for i, op := range ops {
+		if w, g := want.apply(op), got.apply(op); w != g {
+			return fmt.Sprintf("op %d, %s: memfs: %s, S3FS: %s", i, op, w, g)
+		}
+	}
+	if w, g := want.tree(), got.tree(); w != g {
+		return fmt.Sprintf("trees: memfs:\n%s\nS3FS:\n%s", w, g)
+	}
+	return ""
+}
+
+// modelRun is a filesystem and the file opened by the last create, which
+// write, seek and truncate operate on. It is closed by any other
+// operation, since S3FS only uploads files on Close.
+//
+// The oracle is memfs, which differs from S3FS, and from POSIX, in a few
+// known ways: there, expected applies the outcome expected from S3FS
+// instead. S3FS always applies the operation itself.
+type modelRun struct {
+	fs     billy.Filesystem
+	oracle bool
+	file   billy.File
+}
+
+// apply applies op and returns what it observed.
+func (r *modelRun) apply(op modelOp) string {
+	switch op.kind {
+	case "write", "seek", "truncate":
+		if r.file == nil {
+			return "no file"
+		}
+	}
+
+	var closed string
+	if r.file != nil && op.kind != "write" && op.kind != "seek" && op.kind != "truncate" {
+		closed = "close " + errorClass(r.file.Close()) + ", "
+		r.file = nil
+	}
+
+	// S3FS doesn't write markers for the parents of files, which vanish
+	// with their last file, unlike in memfs: parents are made explicit
+	switch op.kind {
+	case "create":
+		closed += r.mkdirParent(op.name)
+	case "rename":
+		closed += r.mkdirParent(op.to)
+	}
+
+	if r.oracle {
+		if want, ok := r.expected(op); ok {
+			return closed + want
+		}
+	}
+
+	switch op.kind {
+	case "create":
+		f, err := r.fs.Create(op.name)
+		if err == nil {
+			r.file = f
+		}
+		return closed + errorClass(err)
+	case "write":
+		n, err := r.file.Write(op.data)
+		return fmt.Sprintf("%d %s", n, errorClass(err))
+	case "seek":
+		pos, err := r.file.Seek(op.offset, op.whence)
+		if err != nil {
+			return errorClass(err)
+		}
+		return fmt.Sprint(pos)
+	case "truncate":
+		return errorClass(r.file.Truncate(op.offset))
+	case "rename":
+		return closed + errorClass(r.fs.Rename(op.name, op.to))
+	case "remove":
+		return closed + errorClass(r.fs.Remove(op.name))
+	case "mkdirall":
+		return closed + errorClass(r.fs.MkdirAll(op.name, 0755))
+	case "readdir":
+		infos, err := r.fs.ReadDir(op.name)
+		if err != nil {
+			return closed + errorClass(err)
+		}
+		entries := make([]string, len(infos))
+		for i, fi := range infos {
+			entries[i] = describe(fi)
+		}
+		sort.Strings(entries)
+		return closed + strings.Join(entries, " ")
+	case "stat":
+		fi, err := r.fs.Stat(op.name)
+		if err != nil {
+			return closed + errorClass(err)
+		}
+		return closed + describe(fi)
+	}
+	panic("unknown operation " + op.kind)
+}
+
+// expected applies op to the oracle, if memfs would do otherwise than
+// S3FS, and returns the outcome expected from S3FS.
+func (r *modelRun) expected(op modelOp) (string, bool) {
+	switch op.kind {
+	case "create":
+		// memfs returns untyped errors
+		if r.isDir(op.name) {
+			return "isdir", true
+		}
+		if r.hasFileParent(op.name) {
+			return "notdir", true
+		}
+	case "seek":
+		// memfs accepts negative positions, unlike os.File and S3FS, which
+		// keep the current one
+		cur, _ := r.file.Seek(0, io.SeekCurrent)
+		size, _ := r.file.Seek(0, io.SeekEnd)
+		r.file.Seek(cur, io.SeekStart)
+		if pos := []int64{0, cur, size}[op.whence] + op.offset; pos < 0 {
+			return "error", true
+		}
+	case "rename":
+		return r.expectedRename(op.name, op.to)
+	case "remove":
+		// memfs returns an untyped error
+		if infos, err := r.fs.ReadDir(op.name); err == nil && len(infos) > 0 && r.isDir(op.name) {
+			return "notempty", true
+		}
+	case "mkdirall":
+		// memfs returns an untyped error
+		if r.isFile(op.name) || r.hasFileParent(op.name) {
+			return "notdir", true
+		}
+	case "readdir":
+		// memfs lists a file as an empty directory
+		if r.isFile(op.name) {
+			return "notdir", true
+		}
+	}
+	return "", false
+}
+
+// expectedRename is expected for a rename of from to to. S3FS never
+// replaces a directory, and moves directories object by object. memfs
+// renames are never used: memfs loses the entries of nested directories,
+// and breaks the paths renamed from or to, creating a file under them
+// panics.
+func (r *modelRun) expectedRename(from, to string) (string, bool) {
+	if _, err := r.fs.Stat(from); err != nil {
+		return errorClass(err), true
+	}
+	switch {
+	case from == to:
+		// memfs deletes a file renamed onto itself
+		return "ok", true
+	case r.isDir(from) && strings.HasPrefix(to, from+"/"):
+		return "invalid", true
+	case r.isDir(to):
+		// memfs replaces empty directories, like rename(2)
+		return "exist", true
+	case r.hasFileParent(to):
+		return "notdir", true
+	case r.isDir(from) && r.isFile(to):
+		return "exist", true
+	}
+	if err := move(r.fs, from, to); err != nil {
+		return errorClass(err), true
+	}
+	return "ok", true
+}
+
+// move moves the file or the directory from, with its entries, to the
+// path to, replacing a file.
+func move(fs billy.Filesystem, from, to string) error {
+	fi, err := fs.Stat(from)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		data, err := util.ReadFile(fs, from)
+		if err != nil {
+			return err
+		}
+		if err := util.WriteFile(fs, to, data, fi.Mode()); err != nil {
+			return err
+		}
+		return fs.Remove(from)
+	}
+
+	if err := fs.MkdirAll(to, 0755); err != nil {
+		return err
+	}
+	infos, err := fs.ReadDir(from)
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		if err := move(fs, path.Join(from, fi.Name()), path.Join(to, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return fs.Remove(from)
+}
+
+func (r *modelRun) isDir(name string) bool {
+	fi, err := r.fs.Stat(name)
+	return err == nil && fi.IsDir()
+}
+
+func (r *modelRun) isFile(name string) bool {
+	fi, err := r.fs.Stat(name)
+	return err == nil && !fi.IsDir()
+}
+
+// hasFileParent reports whether one of the parents of name is a file.
+func (r *modelRun) hasFileParent(name string) bool {
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if r.isFile(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *modelRun) mkdirParent(name string) string {
+	if dir := path.Dir(name); dir != "." {
+		return "mkdirall " + r.apply(modelOp{kind: "mkdirall", name: dir}) + ", "
+	}
+	return ""
+}
+
+// tree closes the open file and returns the files, with their contents,
+// and the directories of the filesystem.
+func (r *modelRun) tree() string {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+	var b strings.Builder
+	var walk func(dir string)
+	walk = func(dir string) {
+		infos, err := r.fs.ReadDir(dir)
+		if err != nil {
+			fmt.Fprintf(&b, "%s: %s\n", dir, errorClass(err))
+			return
+		}
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+		for _, fi := range infos {
+			name := path.Join(dir, fi.Name())
+			if fi.IsDir() {
+				fmt.Fprintf(&b, "%s/\n", name)
+				walk(name)
+				continue
+			}
+			data, err := util.ReadFile(r.fs, name)
+			fmt.Fprintf(&b, "%s %q %s\n", name, data, errorClass(err))
+		}
+	}
+	walk("/")
+	return b.String()
+}
+
+func describe(fi os.FileInfo) string {
+	if fi.IsDir() {
+		return fi.Name() + "/"
+	}
+	return fmt.Sprintf("%s:%d", fi.Name(), fi.Size())
+}
+
+// errorClass returns the class of err compared between filesystems, which
+// don't return the same errors.
+func errorClass(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, syscall.ENOTEMPTY):
+		// also os.ErrExist
+		return "notempty"
+	case errors.Is(err, syscall.ENOTDIR):
+		return "notdir"
+	case errors.Is(err, syscall.EISDIR):
+		return "isdir"
+	case errors.Is(err, os.ErrNotExist):
+		return "not-exist"
+	case errors.Is(err, os.ErrExist):
+		return "exist"
+	case errors.Is(err, os.ErrInvalid):
+		return "invalid"
+	case errors.Is(err, io.EOF):
+		return "eof"
+	}
+	return "error"
+}
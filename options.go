@@ -0,0 +1,208 @@
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// DefaultTempDir is the directory, relative to the root of the
+	// filesystem, where TempFile creates files when no directory is given.
+	DefaultTempDir = ".tmp"
+
+	// DefaultTimeout is the default timeout of the S3 requests that only
+	// transfer metadata, such as HeadObject or ListObjectsV2.
+	DefaultTimeout = 30 * time.Second
+
+	// DefaultPartSize is the default size of the parts of a streaming
+	// upload.
+	DefaultPartSize = 8 << 20
+
+	// DefaultUploadConcurrency is the default number of parts of a
+	// streaming upload that are sent in parallel.
+	DefaultUploadConcurrency = 4
+)
+
+// Limits of a multipart upload set by S3.
+const (
+	minPartSize    = 5 << 20
+	maxPartSize    = 5 << 30
+	maxUploadParts = 10000
+)
+
+// Option configures the filesystem returned by New.
+type Option func(*options)
+
+// options holds the configuration of a filesystem. It is shared by the
+// filesystems returned by Chroot and must not be modified after New.
+type options struct {
+	tempDir           string
+	readOnly          bool
+	lockLease         time.Duration
+	timeout           time.Duration
+	prefix            string
+	storageClass      types.StorageClass
+	partSize          int64
+	uploadConcurrency int
+	metadata          map[string]string
+	contentType       func(key string) string
+	errorHook         func(op, path string, err error)
+}
+
+func defaultOptions() *options {
+	return &options{
+		tempDir:           DefaultTempDir,
+		lockLease:         DefaultLockLease,
+		timeout:           DefaultTimeout,
+		partSize:          DefaultPartSize,
+		uploadConcurrency: DefaultUploadConcurrency,
+	}
+}
+
+// validate checks the options against the limits of S3.
+func (o *options) validate() error {
+	if o.partSize < minPartSize || o.partSize > maxPartSize {
+		return fmt.Errorf("part size %d out of range [%d, %d]", o.partSize, minPartSize, maxPartSize)
+	}
+	if o.uploadConcurrency < 1 {
+		return fmt.Errorf("upload concurrency must be positive, got %d", o.uploadConcurrency)
+	}
+	return nil
+}
+
+// withTimeout returns a context for a metadata request derived from ctx,
+// which is cancelled after the configured timeout.
+func (o *options) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, o.timeout)
+}
+
+// WithTempDir sets the directory used by TempFile when no directory is
+// given. It is relative to the root of the filesystem and is subject to
+// Chroot like any other path.
+func WithTempDir(dir string) Option {
+	return func(o *options) {
+		o.tempDir = dir
+	}
+}
+
+// WithReadOnly makes the filesystem read-only: every operation that would
+// modify the bucket fails with billy.ErrReadOnly, and Capabilities reports
+// neither writing nor truncation.
+func WithReadOnly() Option {
+	return func(o *options) {
+		o.readOnly = true
+	}
+}
+
+// WithLockLease sets the lease duration of the locks acquired with
+// File.Lock, DefaultLockLease by default. A lock is held as long as its
+// owner keeps renewing the lease, and is taken over by another owner once
+// the lease has expired, so a shorter lease recovers faster from crashed
+// owners at the cost of more requests. A non-positive lease keeps the
+// default.
+func WithLockLease(lease time.Duration) Option {
+	return func(o *options) {
+		if lease > 0 {
+			o.lockLease = lease
+		}
+	}
+}
+
+// WithTimeout sets the timeout of each S3 request that only transfers
+// metadata, such as HeadObject, ListObjectsV2 or DeleteObject, which is
+// DefaultTimeout by default. Requests transferring file content are not
+// limited, as their duration depends on the size of the file. A
+// non-positive timeout disables the limit.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithPrefix confines the filesystem to the objects under prefix, which
+// becomes its root: the file "a/b" is stored as the object "prefix/a/b".
+// Paths cannot escape the prefix, like with Chroot.
+func WithPrefix(prefix string) Option {
+	return func(o *options) {
+		o.prefix = prefix
+	}
+}
+
+// WithStorageClass sets the storage class of the objects written by the
+// filesystem. By default, the default storage class of the bucket is used.
+// Lock objects always use the default storage class.
+func WithStorageClass(class types.StorageClass) Option {
+	return func(o *options) {
+		o.storageClass = class
+	}
+}
+
+// WithPartSize sets the size of the parts of a streaming upload, which is
+// also the size of the largest file uploaded with a single PutObject. It
+// must be within the limits of S3, between 5 MiB and 5 GiB, and is
+// DefaultPartSize by default. At most size times the upload concurrency
+// bytes are buffered per file. Since an upload has at most 10,000 parts,
+// size also limits the size of the files written, to about 78 GiB by
+// default.
+func WithPartSize(size int64) Option {
+	return func(o *options) {
+		o.partSize = size
+	}
+}
+
+// WithUploadConcurrency sets the number of parts of a streaming upload that
+// are sent in parallel, DefaultUploadConcurrency by default.
+func WithUploadConcurrency(n int) Option {
+	return func(o *options) {
+		o.uploadConcurrency = n
+	}
+}
+
+// WithMetadata sets user metadata added to every file created by the
+// filesystem. The POSIX attributes of the file take precedence over keys
+// of the same name. Existing files keep their metadata when they are
+// overwritten.
+func WithMetadata(meta map[string]string) Option {
+	return func(o *options) {
+		o.metadata = make(map[string]string, len(meta))
+		for k, v := range meta {
+			o.metadata[k] = v
+		}
+	}
+}
+
+// WithContentType sets the function that returns the Content-Type of the
+// object key when a file is written. An empty result leaves it to S3,
+// which defaults to "binary/octet-stream". By default no Content-Type is
+// sent. See ContentTypeByExtension.
+func WithContentType(fn func(key string) string) Option {
+	return func(o *options) {
+		o.contentType = fn
+	}
+}
+
+// WithErrorHook sets a function called with the S3 error behind an
+// os.ErrNotExist, os.ErrExist or os.ErrPermission reported by the
+// operation op on path. Operations report these as the bare sentinel, which
+// os.IsNotExist and the like require, so the hook is the only way to
+// inspect the error of the S3 client, e.g. its request ID, with errors.As.
+// It may be called concurrently.
+func WithErrorHook(fn func(op, path string, err error)) Option {
+	return func(o *options) {
+		o.errorHook = fn
+	}
+}
+
+// ContentTypeByExtension returns the MIME type of the extension of key,
+// or "" if it is unknown. It can be used with WithContentType.
+func ContentTypeByExtension(key string) string {
+	return mime.TypeByExtension(path.Ext(key))
+}
@@ -0,0 +1,210 @@
+package s3fs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/gurza/go-billy-s3fs/s3fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithTempDir(t *testing.T) {
+	client := s3.New(s3.Options{Region: "us-east-1"})
+
+	fsys, err := New(client, "bucket")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultTempDir, fsys.(*S3FS).TempDir())
+
+	fsys, err = New(client, "bucket", WithTempDir("scratch"))
+	require.NoError(t, err)
+	assert.Equal(t, "scratch", fsys.(*S3FS).TempDir())
+
+	// the configuration is shared with chrooted filesystems; the root
+	// resolves without requests
+	sub, err := fsys.Chroot("/")
+	require.NoError(t, err)
+	assert.Equal(t, "scratch", sub.(*S3FS).TempDir())
+}
+
+func TestWithReadOnly(t *testing.T) {
+	client := s3.New(s3.Options{Region: "us-east-1"})
+
+	fsys, err := New(client, "bucket")
+	require.NoError(t, err)
+	assert.True(t, billy.CapabilityCheck(fsys, billy.WriteCapability|billy.ReadAndWriteCapability|billy.TruncateCapability))
+	assert.True(t, billy.CapabilityCheck(fsys, billy.LockCapability))
+
+	fsys, err = New(client, "bucket", WithReadOnly())
+	require.NoError(t, err)
+	assert.Equal(t, billy.ReadCapability|billy.SeekCapability, billy.Capabilities(fsys))
+
+	// nothing is sent to S3, the operations are rejected upfront
+	_, err = fsys.Create("file")
+	assert.ErrorIs(t, err, billy.ErrReadOnly)
+	_, err = fsys.OpenFile("file", os.O_RDONLY|os.O_CREATE, 0644)
+	assert.ErrorIs(t, err, billy.ErrReadOnly)
+	assert.ErrorIs(t, fsys.Remove("file"), billy.ErrReadOnly)
+	assert.ErrorIs(t, fsys.Rename("a", "b"), billy.ErrReadOnly)
+	assert.ErrorIs(t, fsys.MkdirAll("dir", 0755), billy.ErrReadOnly)
+	assert.ErrorIs(t, fsys.Symlink("a", "b"), billy.ErrReadOnly)
+	assert.ErrorIs(t, fsys.(*S3FS).RemoveAll("dir"), billy.ErrReadOnly)
+	assert.ErrorIs(t, fsys.(*S3FS).Chmod("file", 0600), billy.ErrReadOnly)
+}
+
+func TestWithLockLease(t *testing.T) {
+	client := s3.New(s3.Options{Region: "us-east-1"})
+
+	fsys, err := New(client, "bucket")
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLockLease, fsys.(*S3FS).opts.lockLease)
+	assert.True(t, billy.CapabilityCheck(fsys, billy.LockCapability))
+
+	fsys, err = New(client, "bucket", WithLockLease(time.Minute))
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, fsys.(*S3FS).opts.lockLease)
+
+	fsys, err = New(client, "bucket", WithLockLease(0))
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLockLease, fsys.(*S3FS).opts.lockLease)
+
+	// a read-only filesystem cannot write lock objects, nothing is sent
+	fsys, err = New(client, "bucket", WithReadOnly())
+	require.NoError(t, err)
+	f := newRangeFile(fsys.(*S3FS), "/file", "file", nil, 0)
+	assert.ErrorIs(t, f.Lock(), ErrLockNotSupported)
+	assert.ErrorIs(t, f.Unlock(), ErrLockNotSupported)
+}
+
+func TestNew_Options(t *testing.T) {
+	client := s3.New(s3.Options{Region: "us-east-1"})
+
+	fsys, err := New(client, "bucket")
+	require.NoError(t, err)
+	o := fsys.(*S3FS).opts
+	assert.Equal(t, DefaultTimeout, o.timeout)
+	assert.Equal(t, int64(DefaultPartSize), o.partSize)
+	assert.Equal(t, DefaultUploadConcurrency, o.uploadConcurrency)
+	assert.Equal(t, "/", fsys.Root())
+
+	meta := map[string]string{"Team": "infra"}
+	fsys, err = New(client, "bucket",
+		WithTimeout(time.Minute),
+		WithPrefix("repos/project/"),
+		WithStorageClass(types.StorageClassStandardIa),
+		WithPartSize(16<<20),
+		WithUploadConcurrency(2),
+		WithMetadata(meta),
+		WithContentType(ContentTypeByExtension),
+	)
+	require.NoError(t, err)
+	meta["Team"] = "changed"
+
+	fs := fsys.(*S3FS)
+	assert.Equal(t, time.Minute, fs.opts.timeout)
+	assert.Equal(t, "/repos/project", fs.Root())
+	assert.Equal(t, types.StorageClassStandardIa, fs.opts.storageClass)
+	assert.Equal(t, int64(16<<20), fs.opts.partSize)
+	assert.Equal(t, 2, fs.opts.uploadConcurrency)
+	assert.Equal(t, map[string]string{"Team": "infra"}, fs.opts.metadata)
+	assert.Equal(t, "text/html; charset=utf-8", aws.ToString(fs.contentType("repos/project/index.html")))
+	assert.Nil(t, fs.contentType("repos/project/HEAD"))
+
+	// the prefix cannot be escaped
+	_, err = fs.lkey("../../other")
+	assert.ErrorIs(t, err, billy.ErrCrossedBoundary)
+	assert.Equal(t, "repos/project", fs.root)
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	client := s3.New(s3.Options{Region: "us-east-1"})
+
+	for _, opt := range []Option{
+		WithPartSize(1 << 20),
+		WithPartSize(6 << 30),
+		WithUploadConcurrency(0),
+	} {
+		_, err := New(client, "bucket", opt)
+		assert.Error(t, err)
+	}
+}
+
+func TestWithTimeout(t *testing.T) {
+	o := defaultOptions()
+	ctx, cancel := o.withTimeout(context.Background())
+	defer cancel()
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(DefaultTimeout), deadline, time.Second)
+
+	WithTimeout(0)(o)
+	ctx, cancel = o.withTimeout(context.Background())
+	defer cancel()
+	_, ok = ctx.Deadline()
+	assert.False(t, ok)
+}
+
+// slowLister is an S3API whose listings take delay per page.
+type slowLister struct {
+	S3API
+	delay time.Duration
+}
+
+func (c slowLister) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	select {
+	case <-time.After(c.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return c.S3API.ListObjectsV2(ctx, params, optFns...)
+}
+
+func TestWithTimeout_Listings(t *testing.T) {
+	client := s3fstest.NewClient("bucket")
+	for i := 0; i < 2500; i++ {
+		_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String(fmt.Sprintf("dir/%04d", i)),
+			Body:   strings.NewReader(""),
+		})
+		require.NoError(t, err)
+	}
+
+	// the timeout applies to each page, not to the whole listing
+	fsys, err := New(slowLister{client, 60 * time.Millisecond}, "bucket", WithTimeout(100*time.Millisecond))
+	require.NoError(t, err)
+	infos, err := fsys.ReadDir("dir")
+	require.NoError(t, err)
+	assert.Len(t, infos, 2500)
+}
+
+func TestWithStorageClass_Rename(t *testing.T) {
+	fsys, client := newTestFS(t, WithStorageClass(types.StorageClassStandardIa))
+	class := func(key string) types.StorageClass {
+		out, err := client.HeadObject(context.Background(), &s3.HeadObjectInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String(key),
+		})
+		require.NoError(t, err)
+		return out.StorageClass
+	}
+
+	require.NoError(t, util.WriteFile(fsys, "file", []byte("data"), 0644))
+	require.NoError(t, util.WriteFile(fsys, "dir/file", []byte("data"), 0644))
+	require.Equal(t, types.StorageClassStandardIa, class("file"))
+
+	// renamed objects keep their class
+	require.NoError(t, fsys.Rename("file", "moved"))
+	assert.Equal(t, types.StorageClassStandardIa, class("moved"))
+	require.NoError(t, fsys.Rename("dir", "other"))
+	assert.Equal(t, types.StorageClassStandardIa, class("other/file"))
+}
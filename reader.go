@@ -0,0 +1,209 @@
+package s3fs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/go-git/go-billy/v5"
+)
+
+// rangeFile is a read-only billy.File backed by HTTP Range requests.
+//
+// Nothing is downloaded when the file is opened. Sequential reads share a
+// single streaming GetObject response, which is reopened at the new offset
+// after a Seek, and every ReadAt fetches exactly the requested range. All
+// requests are pinned to the ETag seen on open, so a concurrent overwrite
+// of the object fails the read instead of mixing two versions.
+type rangeFile struct {
+	fs     *S3FS
+	key    string
+	name   string
+	etag   *string
+	size   int64
+	pos    int64
+	closed bool
+	lock   fileLock
+
+	body    io.ReadCloser // open response body, positioned at bodyPos
+	bodyPos int64
+}
+
+var _ billy.File = (*rangeFile)(nil)
+
+func newRangeFile(fs *S3FS, key, name string, etag *string, size int64) *rangeFile {
+	return &rangeFile{
+		fs:   fs,
+		key:  key,
+		name: name,
+		etag: etag,
+		size: size,
+	}
+}
+
+func (f *rangeFile) Read(b []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.pos >= f.size {
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if f.body == nil || f.bodyPos != f.pos {
+		f.closeBody()
+		body, err := f.fs.readRange(f.key, f.etag, f.pos, -1)
+		if err != nil {
+			return 0, f.fs.pathError("read", f.name, err)
+		}
+		f.body, f.bodyPos = body, f.pos
+	}
+
+	n, err := f.body.Read(b)
+	f.pos += int64(n)
+	f.bodyPos += int64(n)
+	if err == io.EOF {
+		f.closeBody()
+		if n == 0 && f.pos < f.size {
+			return 0, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	if err != nil {
+		return n, f.fs.pathError("read", f.name, err)
+	}
+	return n, nil
+}
+
+func (f *rangeFile) ReadAt(b []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off < 0 {
+		return 0, errors.New("s3fs.rangeFile.ReadAt: negative offset")
+	}
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	end := off + int64(len(b))
+	if end > f.size {
+		end = f.size
+	}
+	body, err := f.fs.readRange(f.key, f.etag, off, end-1)
+	if err != nil {
+		return 0, f.fs.pathError("readat", f.name, err)
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, b[:end-off])
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return n, err
+	}
+	if err != nil {
+		return n, f.fs.pathError("readat", f.name, err)
+	}
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *rangeFile) Write(b []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *rangeFile) Truncate(size int64) error {
+	return os.ErrPermission
+}
+
+func (f *rangeFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = f.pos + offset
+	case io.SeekEnd:
+		abs = f.size + offset
+	default:
+		return 0, errors.New("s3fs.rangeFile.Seek: invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("s3fs.rangeFile.Seek: negative position")
+	}
+
+	// the open body, if any, is reused or dropped by the next Read
+	f.pos = abs
+	return abs, nil
+}
+
+func (f *rangeFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	f.closeBody()
+	f.lock.release(f.fs)
+	return nil
+}
+
+func (f *rangeFile) Name() string {
+	return f.name
+}
+
+func (f *rangeFile) Size() int64 {
+	return f.size
+}
+
+func (f *rangeFile) Lock() error {
+	return f.lock.lock(f.fs, f.key)
+}
+
+func (f *rangeFile) Unlock() error {
+	return f.lock.unlock(f.fs)
+}
+
+func (f *rangeFile) closeBody() {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+}
+
+// readRange retrieves the bytes from off to end (inclusive) of the object
+// from S3. A negative end reads up to the end of the object. If etag is
+// set, the request fails if the object has changed.
+func (fs *S3FS) readRange(key string, etag *string, off, end int64) (io.ReadCloser, error) {
+	resp, err := fs.client.GetObject(fs.ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(fs.bucket),
+		Key:     aws.String(key),
+		Range:   aws.String(httpRange(off, end)),
+		IfMatch: etag,
+	})
+	if err != nil {
+		return nil, mapError(err)
+	}
+	return resp.Body, nil
+}
+
+// httpRange formats the value of a Range header for the bytes from off to
+// end (inclusive), or to the end of the content if end is negative.
+func httpRange(off, end int64) string {
+	if end < 0 {
+		return fmt.Sprintf("bytes=%d-", off)
+	}
+	return fmt.Sprintf("bytes=%d-%d", off, end)
+}
@@ -0,0 +1,220 @@
+package s3fs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/go-git/go-billy/v5"
+)
+
+const (
+	// maxDeleteObjects is the maximum number of keys S3 accepts in a single
+	// DeleteObjects request.
+	maxDeleteObjects = 1000
+
+	// deleteConcurrency is the number of DeleteObjects requests sent in
+	// parallel.
+	deleteConcurrency = 4
+)
+
+// billy's util.RemoveAll uses the RemoveAll method when it is available.
+var _ interface{ RemoveAll(string) error } = (*S3FS)(nil)
+
+// RemoveAll removes name and any children it contains. It returns nil if
+// name does not exist, and fails with syscall.EBUSY for the root of the
+// filesystem. It is used by billy's util.RemoveAll instead of
+// walking the tree and removing one file at a time.
+//
+// The objects under name are listed with a flat listing and deleted in
+// batches of up to 1000 keys with DeleteObjects while the listing goes on.
+// Keys that fail to be deleted don't stop the removal; their errors are
+// joined into the returned error.
+func (fs *S3FS) RemoveAll(name string) error {
+	if fs.opts.readOnly {
+		return &os.PathError{Op: "removeall", Path: name, Err: billy.ErrReadOnly}
+	}
+	key, err := fs.lkey(name)
+	if err != nil {
+		return fs.pathError("removeall", name, err)
+	}
+	if key == fs.root {
+		// like Remove, never the whole filesystem
+		return &os.PathError{Op: "removeall", Path: name, Err: syscall.EBUSY}
+	}
+
+	// cancelled by a failed delete, or with the context of fs
+	ctx, cancel := context.WithCancel(fs.ctx)
+	defer cancel()
+
+	batches := make(chan []string, deleteConcurrency)
+	var listErr error
+	go func() {
+		defer close(batches)
+		// name itself, in case it is a file
+		batches <- []string{key}
+		listErr = fs.listBatches(ctx, dirPrefix(key), batches)
+	}()
+
+	errs := fs.deleteBatches(ctx, cancel, batches)
+	if err := errors.Join(appendErr(errs, listErr)...); err != nil {
+		return fs.pathError("removeall", name, err)
+	}
+	return nil
+}
+
+// listBatches sends the keys of all objects starting with prefix to
+// batches, one batch per page of a flat listing. ctx is derived from the
+// context of fs; if it is cancelled by a failed delete, listBatches stops
+// and returns nil, but if the context of fs is done, it returns its error.
+func (fs *S3FS) listBatches(ctx context.Context, prefix string, batches chan<- []string) error {
+	paginator := s3.NewListObjectsV2Paginator(fs.client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(fs.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(maxDeleteObjects),
+	})
+	for paginator.HasMorePages() {
+		pageCtx, cancel := fs.opts.withTimeout(ctx)
+		page, err := paginator.NextPage(pageCtx)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				// stopped because a delete failed, which is reported, or
+				// cancelled by the caller
+				return fs.ctx.Err()
+			}
+			return fmt.Errorf("failed to list objects: %w", mapError(err))
+		}
+		if len(page.Contents) == 0 {
+			continue
+		}
+
+		keys := make([]string, 0, len(page.Contents))
+		for _, obj := range page.Contents {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+		select {
+		case batches <- keys:
+		case <-ctx.Done():
+			return fs.ctx.Err()
+		}
+	}
+	return nil
+}
+
+// deleteBatches deletes the keys received from batches with parallel
+// DeleteObjects requests until batches is closed, and returns the errors
+// of the keys that could not be deleted. A failed request cancels ctx to
+// stop the producer; the remaining batches are drained but not deleted.
+// Batches left undeleted because the context of fs is done are reported
+// with its error.
+func (fs *S3FS) deleteBatches(ctx context.Context, cancel context.CancelFunc, batches <-chan []string) []error {
+	var (
+		mu      sync.Mutex
+		errs    []error
+		skipped bool
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < deleteConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for keys := range batches {
+				if ctx.Err() != nil {
+					mu.Lock()
+					skipped = true
+					mu.Unlock()
+					continue
+				}
+				batchErrs, err := fs.deleteObjects(ctx, keys)
+				mu.Lock()
+				errs = append(errs, batchErrs...)
+				if err != nil {
+					errs = append(errs, err)
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if skipped {
+		errs = appendErr(errs, fs.ctx.Err())
+	}
+	return errs
+}
+
+// appendErr appends err to errs, unless it is nil or one of errs already
+// is err, e.g. when the listing and the deletes are stopped by the same
+// cancellation.
+func appendErr(errs []error, err error) []error {
+	if err == nil || errors.Is(errors.Join(errs...), err) {
+		return errs
+	}
+	return append(errs, err)
+}
+
+// deleteObjects deletes up to maxDeleteObjects keys with a single request.
+// Failures of individual keys are returned as keyErrs, a failure of the
+// whole request as err.
+func (fs *S3FS) deleteObjects(ctx context.Context, keys []string) (keyErrs []error, err error) {
+	ctx, cancel := fs.opts.withTimeout(ctx)
+	defer cancel()
+
+	objects := make([]types.ObjectIdentifier, 0, len(keys))
+	for _, key := range keys {
+		objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+	}
+	output, err := fs.client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(fs.bucket),
+		Delete: &types.Delete{
+			Objects: objects,
+			Quiet:   aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete objects: %w", mapError(err))
+	}
+
+	for _, e := range output.Errors {
+		apiErr := &smithy.GenericAPIError{Code: aws.ToString(e.Code), Message: aws.ToString(e.Message)}
+		keyErrs = append(keyErrs, fmt.Errorf("%s: %w", aws.ToString(e.Key), mapError(apiErr)))
+	}
+	return keyErrs, nil
+}
+
+// deleteKeys deletes the objects with the given keys in batches of up to
+// maxDeleteObjects keys. It fails with the error of the context of fs if
+// it is done before every key is deleted.
+func (fs *S3FS) deleteKeys(keys []string) error {
+	// cancelled by a failed delete, or with the context of fs
+	ctx, cancel := context.WithCancel(fs.ctx)
+	defer cancel()
+
+	batches := make(chan []string, deleteConcurrency)
+	var stopErr error
+	go func() {
+		defer close(batches)
+		for len(keys) > 0 {
+			n := min(len(keys), maxDeleteObjects)
+			select {
+			case batches <- keys[:n]:
+			case <-ctx.Done():
+				stopErr = fs.ctx.Err()
+				return
+			}
+			keys = keys[n:]
+		}
+	}()
+
+	errs := fs.deleteBatches(ctx, cancel, batches)
+	return errors.Join(appendErr(errs, stopErr)...)
+}
@@ -0,0 +1,145 @@
+package s3fs
+
+import (
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/go-git/go-billy/v5"
+)
+
+// Object keys are canonical: they have no leading or trailing slash and no
+// "." or ".." elements. The root of a filesystem is a key as well, "" for
+// the root of the bucket, and every key resolved in a filesystem is either
+// its root or below it. A directory is represented by the prefix of its
+// key (see dirPrefix) and, optionally, a marker object at that prefix.
+
+// key returns the object key of name, following symbolic links. Every
+// operation that follows links resolves its paths with key. Paths in
+// LockDir are reserved and fail with os.ErrPermission.
+func (fs *S3FS) key(name string) (string, error) {
+	return fs.checkReserved(resolveKey(fs.root, name, true, keyVFS{fs}))
+}
+
+// lkey is like key, but doesn't follow a symbolic link in the last element
+// of name, like Lstat and Readlink.
+func (fs *S3FS) lkey(name string) (string, error) {
+	return fs.checkReserved(resolveKey(fs.root, name, false, keyVFS{fs}))
+}
+
+// checkReserved fails with os.ErrPermission if key, resolved with err, is
+// LockDir or is in it.
+func (fs *S3FS) checkReserved(key string, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	if dir := fs.lockDir(); key == dir || strings.HasPrefix(key, dirPrefix(dir)) {
+		return "", os.ErrPermission
+	}
+	return key, nil
+}
+
+// resolveKey returns the canonical key of name in the filesystem rooted at
+// the key root. name is relative to root, whether it starts with a slash
+// or not. It fails with billy.ErrCrossedBoundary if name lexically escapes
+// root, e.g. "../file".
+//
+// Symbolic links, read from vfs, are followed in every element of name but
+// the last one, which is only followed if follow is set. Links can't lead
+// out of root either: an absolute target is relative to root, and ".."
+// elements stop at root, as with securejoin. For example, if root is
+// "base" and name is "lnk1/lnk2/file", where "lnk1" is a link to "/tgt1"
+// and "lnk2" a link to "/tgt2", the key is "base/tgt2/file".
+func resolveKey(root, name string, follow bool, vfs securejoin.VFS) (string, error) {
+	if c := path.Clean(name); c == ".." || strings.HasPrefix(c, "../") {
+		return "", billy.ErrCrossedBoundary
+	}
+	rel := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if rel == "" {
+		return root, nil
+	}
+
+	var key string
+	if follow {
+		p, err := securejoin.SecureJoinVFS(root, rel, vfs)
+		if err != nil {
+			return "", err
+		}
+		key = vfsKey(p)
+	} else {
+		dir, err := resolveKey(root, path.Dir(rel), true, vfs)
+		if err != nil {
+			return "", err
+		}
+		key = joinKey(dir, path.Base(rel))
+	}
+
+	// securejoin already confines the key, this guards against its misuse
+	if !isSubPath(root, key) {
+		return "", billy.ErrCrossedBoundary
+	}
+	return key, nil
+}
+
+// joinKey returns the key of the entry name of the directory dir.
+func joinKey(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// dirPrefix returns the prefix of the keys of the entries of the directory
+// key, which is also the key of its marker object.
+func dirPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+// relKey returns key relative to the directory root, of which it must be
+// a descendant or root itself.
+func relKey(root, key string) string {
+	if key == root {
+		return ""
+	}
+	return strings.TrimPrefix(key, dirPrefix(root))
+}
+
+// vfsKey returns the key of a path built by securejoin, which joins the
+// root key and the path elements with slashes.
+func vfsKey(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// keyVFS is the securejoin.VFS used to resolve keys. It reads symbolic
+// links from S3.
+type keyVFS struct {
+	fs *S3FS
+}
+
+var _ securejoin.VFS = keyVFS{}
+
+func (v keyVFS) Lstat(name string) (os.FileInfo, error) {
+	key := vfsKey(name)
+	output, err := v.fs.headObject(key)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	return fileInfo(path.Base(key), output), nil
+}
+
+func (v keyVFS) Readlink(name string) (string, error) {
+	output, err := v.fs.headObject(vfsKey(name))
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	target, ok := symlinkTarget(output.Metadata)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return target, nil
+}
@@ -0,0 +1,298 @@
+package s3fs
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// linkVFS is a securejoin.VFS with the symbolic links of a bucket, mapping
+// their keys to their targets. Any other key doesn't exist.
+type linkVFS map[string]string
+
+func (v linkVFS) Lstat(name string) (os.FileInfo, error) {
+	key := vfsKey(name)
+	target, ok := v[key]
+	if !ok {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	}
+	return newSymlinkInfo(path.Base(key), target, time.Time{}), nil
+}
+
+func (v linkVFS) Readlink(name string) (string, error) {
+	target, ok := v[vfsKey(name)]
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrNotExist}
+	}
+	return target, nil
+}
+
+func TestResolveKey(t *testing.T) {
+	links := linkVFS{
+		"base/lnk":     "/tgt",
+		"base/rel":     "../../../tgt",
+		"base/dir/up":  "..",
+		"base/loop":    "loop",
+		"other/escape": "/base",
+	}
+	tests := []struct {
+		root   string
+		name   string
+		follow bool
+		want   string
+	}{
+		{"", "", true, ""},
+		{"", "/", true, ""},
+		{"", "a/b", true, "a/b"},
+		{"", "/a//b/./c/", true, "a/b/c"},
+		{"", "a/../b", true, "b"},
+		{"", "/../a", true, "a"},
+		{"base", ".", true, "base"},
+		{"base", "a/b", true, "base/a/b"},
+		{"base", "lnk/file", true, "base/tgt/file"},
+		{"base", "lnk", true, "base/tgt"},
+		{"base", "lnk", false, "base/lnk"},
+		{"base", "rel/file", true, "base/tgt/file"},
+		{"base", "dir/up/file", true, "base/file"},
+		{"base", "dir/up", false, "base/dir/up"},
+		{"", "base/lnk/file", true, "tgt/file"},
+	}
+	for _, tt := range tests {
+		got, err := resolveKey(tt.root, tt.name, tt.follow, links)
+		if assert.NoError(t, err, "%q in %q", tt.name, tt.root) {
+			assert.Equal(t, tt.want, got, "%q in %q", tt.name, tt.root)
+		}
+	}
+
+	for _, name := range []string{"..", "../a", "a/../..", "a/../../b"} {
+		_, err := resolveKey("base", name, true, links)
+		assert.ErrorIs(t, err, billy.ErrCrossedBoundary, name)
+		_, err = resolveKey("base", name, false, links)
+		assert.ErrorIs(t, err, billy.ErrCrossedBoundary, name)
+	}
+
+	_, err := resolveKey("base", "loop/file", true, links)
+	assert.Error(t, err)
+}
+
+// randomName returns a path made of a few elements, including empty, "."
+// and ".." elements, with or without a leading and a trailing slash.
+func randomName(r *rand.Rand) string {
+	elems := []string{"", ".", "..", "a", "b", "lnk", "abs", "up"}
+	n := r.Intn(6)
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = elems[r.Intn(len(elems))]
+	}
+	name := strings.Join(parts, "/")
+	if r.Intn(3) == 0 {
+		name = "/" + name
+	}
+	if r.Intn(4) == 0 {
+		name += "/"
+	}
+	return name
+}
+
+// isCanonical reports whether key is a canonical object key.
+func isCanonical(key string) bool {
+	if key == "" {
+		return true
+	}
+	for _, elem := range strings.Split(key, "/") {
+		if elem == "" || elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func TestResolveKey_Properties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	roots := []string{"", "base", "base/a"}
+	links := linkVFS{}
+	for _, root := range append(roots, "base/b") {
+		links[joinKey(root, "lnk")] = "b/../a"
+		links[joinKey(root, "abs")] = "/a"
+		links[joinKey(root, "up")] = "../../.."
+	}
+
+	for i := 0; i < 5000; i++ {
+		root := roots[r.Intn(len(roots))]
+		name := randomName(r)
+
+		key, err := resolveKey(root, name, true, links)
+		if c := path.Clean(name); c == ".." || strings.HasPrefix(c, "../") {
+			require.ErrorIs(t, err, billy.ErrCrossedBoundary, "%q in %q", name, root)
+			continue
+		}
+		require.NoError(t, err, "%q in %q", name, root)
+		lkey, err := resolveKey(root, name, false, links)
+		require.NoError(t, err, "%q in %q", name, root)
+
+		for _, k := range []string{key, lkey} {
+			// keys are canonical and confined to the root
+			require.True(t, isCanonical(k), "%q in %q: %q", name, root, k)
+			require.True(t, k == root || strings.HasPrefix(k, dirPrefix(root)), "%q in %q: %q", name, root, k)
+
+			// resolving a key again, relative to the root, is a no-op: what
+			// Create writes is found by Stat
+			again, err := resolveKey(root, relKey(root, k), false, linkVFS{})
+			require.NoError(t, err)
+			require.Equal(t, k, again, "%q in %q", name, root)
+		}
+
+		// ReadDir of the parent lists the entry by its base name under the
+		// prefix of the parent
+		if lkey != root {
+			parent, err := resolveKey(root, path.Dir(path.Clean("/"+name)), true, links)
+			require.NoError(t, err)
+			require.Equal(t, lkey, dirPrefix(parent)+path.Base(lkey), "%q in %q", name, root)
+		}
+	}
+}
+
+func TestResolveKey_Operations(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+
+	// the same properties, through the operations of a filesystem: what
+	// Create writes, wherever the links lead, is found by Stat, by ReadDir
+	// of its directory and in a chroot at any of its parents
+	for i := 0; i < 500; i++ {
+		prefix := []string{"", "base", "base/a"}[r.Intn(3)]
+		fsys, client := newTestFS(t, WithPrefix(prefix))
+		require.NoError(t, fsys.Symlink("b/../a", "lnk"))
+		require.NoError(t, fsys.Symlink("/a", "abs"))
+		require.NoError(t, fsys.Symlink("../../..", "up"))
+		links := client.Keys("bucket")
+
+		name := randomName(r)
+		f, err := fsys.Create(name)
+		if c := path.Clean(name); c == ".." || strings.HasPrefix(c, "../") {
+			require.ErrorIs(t, err, billy.ErrCrossedBoundary, "%q in %q", name, prefix)
+			continue
+		}
+		if errors.Is(err, syscall.EISDIR) {
+			// the root, through ".." or links
+			fi, err := fsys.Stat(name)
+			require.NoError(t, err, "%q in %q", name, prefix)
+			require.True(t, fi.IsDir(), "%q in %q", name, prefix)
+			continue
+		}
+		require.NoError(t, err, "%q in %q", name, prefix)
+		_, err = f.Write([]byte(name))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+
+		var key string
+		for _, k := range client.Keys("bucket") {
+			if !slices.Contains(links, k) {
+				require.Empty(t, key, "%q in %q: two objects written", name, prefix)
+				key = k
+			}
+		}
+		rel := relKey(vfsKey(prefix), key)
+		require.NotEmpty(t, rel, "%q in %q: %q", name, prefix, key)
+
+		for _, n := range []string{name, rel, "/" + rel} {
+			fi, err := fsys.Stat(n)
+			require.NoError(t, err, "%q for %q in %q", n, name, prefix)
+			require.False(t, fi.IsDir(), "%q for %q in %q", n, name, prefix)
+			require.Equal(t, int64(len(name)), fi.Size(), "%q for %q in %q", n, name, prefix)
+		}
+
+		infos, err := fsys.ReadDir(path.Dir("/" + rel))
+		require.NoError(t, err, "%q in %q", name, prefix)
+		found := false
+		for _, fi := range infos {
+			if fi.Name() == path.Base(rel) {
+				found = true
+				require.False(t, fi.IsDir(), "%q in %q", name, prefix)
+				require.Equal(t, int64(len(name)), fi.Size(), "%q in %q", name, prefix)
+			}
+		}
+		require.True(t, found, "%q in %q: %q not listed", name, prefix, rel)
+
+		elems := strings.Split(rel, "/")
+		for j := range elems {
+			sub, err := fsys.Chroot(strings.Join(elems[:j], "/"))
+			require.NoError(t, err)
+			fi, err := sub.Stat(strings.Join(elems[j:], "/"))
+			require.NoError(t, err, "%q for %q in %q", rel, name, prefix)
+			require.Equal(t, int64(len(name)), fi.Size(), "%q for %q in %q", rel, name, prefix)
+		}
+	}
+}
+
+// FuzzResolveKey checks that no name, through links to any target, resolves
+// to a key outside the root, and that names escaping it lexically fail with
+// billy.ErrCrossedBoundary.
+func FuzzResolveKey(f *testing.F) {
+	for _, seed := range []struct {
+		root, name, target string
+	}{
+		{"base", "..", ""},
+		{"base", "a/../../b", ""},
+		{"base", "..%2F..%2Fb", ""},
+		{"base", "a%2f..%2f..", ""},
+		{"base", "a\x00/../..", ""},
+		{"base", "lnk/file", "../../.."},
+		{"base", "lnk/file", "/../other"},
+		{"base", "a/lnk/..", "lnk"},
+		{"base/a", "lnk/../..", ".."},
+		{"", "lnk", "..\x00"},
+	} {
+		f.Add(seed.root, seed.name, seed.target, true)
+	}
+	f.Fuzz(func(t *testing.T, root, name, target string, follow bool) {
+		root = vfsKey(root)
+		links := linkVFS{joinKey(root, "lnk"): target, joinKey(root, "a/lnk"): target}
+
+		key, err := resolveKey(root, name, follow, links)
+		if c := path.Clean(name); c == ".." || strings.HasPrefix(c, "../") {
+			require.ErrorIs(t, err, billy.ErrCrossedBoundary, "%q in %q", name, root)
+			return
+		}
+		if err != nil {
+			// e.g. too many levels of links
+			return
+		}
+		require.True(t, isCanonical(key), "%q in %q: %q", name, root, key)
+		require.True(t, key == root || strings.HasPrefix(key, dirPrefix(root)), "%q in %q: %q", name, root, key)
+	})
+}
+
+func TestResolveKey_ChrootAgrees(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+
+	// without links, resolving name in a chroot at dir is the same as
+	// resolving dir/name, unless name climbs out of the chroot
+	for i := 0; i < 5000; i++ {
+		dir, name := randomName(r), randomName(r)
+		if strings.Contains("/"+name+"/", "/../") {
+			// ".." stops at the root of the chroot, or fails
+			continue
+		}
+
+		sub, err := resolveKey("", dir, true, linkVFS{})
+		if err != nil {
+			require.ErrorIs(t, err, billy.ErrCrossedBoundary)
+			continue
+		}
+		inChroot, err := resolveKey(sub, name, true, linkVFS{})
+		require.NoError(t, err)
+		joined, err := resolveKey("", path.Join(path.Clean("/"+dir), name), true, linkVFS{})
+		require.NoError(t, err)
+		require.Equal(t, joined, inChroot, "%q in chroot %q", name, dir)
+	}
+}
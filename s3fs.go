@@ -1,33 +1,45 @@
 package s3fs
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/go-git/go-billy/v5"
 )
 
+// statConcurrency is the number of objects retrieved in parallel to read
+// the attributes of the entries of a directory.
+const statConcurrency = 8
+
 const (
 	PathSeparator   = '/'
-	SupportedOFlags = os.O_RDONLY
+	SupportedOFlags = os.O_RDONLY | os.O_WRONLY | os.O_RDWR |
+		os.O_CREATE | os.O_TRUNC | os.O_APPEND | os.O_EXCL
 )
 
 type S3FS struct {
-	client *s3.Client
+	client S3API
 	bucket string
 	root   string
+	ctx    context.Context
+	opts   *options
 }
 
-// NewS3FS creates a new S3-backed filesystem for the given bucket.
-func New(client *s3.Client, bucket string) (billy.Filesystem, error) {
+// New creates a new S3-backed filesystem for the given bucket, sending its
+// requests with client, usually an *s3.Client.
+func New(client S3API, bucket string, opts ...Option) (billy.Filesystem, error) {
 	if client == nil {
 		return nil, fmt.Errorf("s3 client cannot be nil")
 	}
@@ -35,25 +47,23 @@ func New(client *s3.Client, bucket string) (billy.Filesystem, error) {
 		return nil, fmt.Errorf("bucket name cannot be empty")
 	}
 
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+	if err := o.validate(); err != nil {
+		return nil, err
+	}
+
 	return &S3FS{
 		client: client,
 		bucket: bucket,
-		root:   "/",
+		root:   vfsKey(o.prefix),
+		ctx:    context.Background(),
+		opts:   o,
 	}, nil
 }
 
-// abs converts a filename to an absolute path relative to fs.root,
-// ensuring it doesn't escape the root directory.
-//
-// Symlinks are attempted to be resolved to their target paths. The resolution
-// depends on the implementation of the `Lstat` and `Readlink` methods.
-// For example, if fs.root is "/base" and fn is "lnk1/lnk2/file.go",
-// where "lnk1" is a symlink to "/tgt1" and "lnk2" is a symlink to "/tgt2",
-// the result will be "/base/tgt1/tgt2/file".
-func (fs *S3FS) abs(fn string) (string, error) {
-	return securejoin.SecureJoinVFS(fs.root, fn, fs)
-}
-
 // Create implements billy.Filesystem.
 func (fs *S3FS) Create(name string) (billy.File, error) {
 	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
@@ -65,44 +75,223 @@ func (fs *S3FS) Open(name string) (billy.File, error) {
 }
 
 // OpenFile implements billy.Filesystem.
+//
+// Files opened read-only are read lazily with HTTP Range requests, so
+// opening them is cheap whatever their size. Files opened for writing keep
+// their content in memory while they are open and are uploaded to S3 when
+// they are closed; the upload error, if any, is returned by Close.
+//
+// The permissions (perm) of a newly created file are stored with its
+// POSIX attributes and reported by Stat. Overwriting an existing file
+// keeps its mode and owner.
+//
+// A file opened write-only with empty content (os.O_TRUNC or an exclusive
+// create, without os.O_APPEND) is streamed to S3 with a multipart upload
+// as it is written instead, so its size is not limited by memory. Such a
+// file cannot be read, seeked or truncated.
+//
+// Creating a file fails with syscall.EISDIR if a directory was created at
+// the path with MkdirAll, and with syscall.ENOTDIR if one of its parents
+// is a file.
+//
+// With os.O_CREATE|os.O_EXCL, OpenFile fails with os.ErrExist if the file
+// already exists, or if name is a symbolic link, even a dangling one.
+// Since the object is only created on Close, exclusivity is enforced by a
+// conditional upload: if another writer creates the same file in the
+// meantime, Close fails with os.ErrExist and the first writer wins.
 func (fs *S3FS) OpenFile(name string, flag int, perm os.FileMode) (billy.File, error) {
 	if flag&SupportedOFlags != flag {
-		// todo: support all flags
-		return nil, fmt.Errorf("%w: unsupported OpenFile flag %d", ErrNotImplemented, flag)
+		return nil, &os.PathError{Op: "open", Path: name, Err: fmt.Errorf("%w: unsupported OpenFile flag %d", ErrNotImplemented, flag)}
+	}
+	if fs.opts.readOnly && (isWritable(flag) || flag&(os.O_CREATE|os.O_TRUNC) != 0) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: billy.ErrReadOnly}
 	}
 
-	absPath, err := fs.abs(name)
+	resolve := fs.key
+	if isExclusive(flag) {
+		// like open(2), an exclusive create doesn't follow a link in the
+		// last element, which exists even if its target doesn't
+		resolve = fs.lkey
+	}
+	key, err := resolve(name)
 	if err != nil {
-		return nil, &os.PathError{Op: "openfile", Path: name, Err: err}
+		return nil, fs.pathError("open", name, err)
 	}
 
-	b, err := fs.readObject(absPath)
-	if err != nil {
-		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	if !isWritable(flag) {
+		output, err := fs.headObject(key)
+		if err != nil {
+			return nil, fs.pathError("open", name, err)
+		}
+		return newRangeFile(fs, key, name, output.ETag, aws.ToInt64(output.ContentLength)), nil
 	}
 
-	f, err := newFile(name, b)
-	if err != nil {
-		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	trunc := flag&os.O_TRUNC != 0
+	var (
+		b       []byte
+		meta    map[string]string
+		created bool
+	)
+	if isExclusive(flag) {
+		// a new file is always empty
+		_, err := fs.headObject(key)
+		if err == nil {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return nil, fs.pathError("open", name, err)
+		}
+		created = true
+	} else if trunc {
+		// the content is discarded anyway, only the attributes are kept
+		output, err := fs.headObject(key)
+		switch {
+		case err == nil:
+			meta = output.Metadata
+		case errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0:
+			created = true
+		default:
+			return nil, fs.pathError("open", name, err)
+		}
+	} else {
+		b, meta, err = fs.readObject(key)
+		if errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0 {
+			b, err, created = nil, nil, true
+		}
+		if err != nil {
+			return nil, fs.pathError("open", name, err)
+		}
+	}
+	if created {
+		if err := fs.checkCreate(key); err != nil {
+			return nil, fs.pathError("open", name, err)
+		}
+		meta = newAttrs(perm, fs.opts.metadata)
+	} else {
+		meta = keptAttrs(meta)
 	}
+
+	if isStreaming(flag) {
+		return newUploadFile(fs, key, name, flag, meta), nil
+	}
+
+	f := newFile(fs, key, name, flag, b, meta)
+	// a new or truncated file is uploaded on Close even if nothing is written
+	f.dirty = created || trunc
 	return f, nil
 }
 
-// readObject retrieves the object content from S3.
-func (fs *S3FS) readObject(key string) ([]byte, error) {
-	resp, err := fs.client.GetObject(context.TODO(), &s3.GetObjectInput{
+// checkCreate checks that a file can be created at the object key, which
+// doesn't exist yet. It fails with syscall.EISDIR if key has a directory
+// marker, and with syscall.ENOTDIR if one of its parents is a file.
+// Directories without marker object are not detected, as that would cost
+// a listing.
+func (fs *S3FS) checkCreate(key string) error {
+	if key == fs.root {
+		return syscall.EISDIR
+	}
+	_, err := fs.headObject(dirPrefix(key))
+	if err == nil {
+		return syscall.EISDIR
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return fs.checkParents(key)
+}
+
+// checkParents fails with syscall.ENOTDIR if one of the parents of the
+// object key, up to the root, is a file.
+func (fs *S3FS) checkParents(key string) error {
+	dir := fs.root
+	elems := strings.Split(relKey(fs.root, key), "/")
+	for _, elem := range elems[:len(elems)-1] {
+		dir = joinKey(dir, elem)
+		_, err := fs.headObject(dir)
+		if err == nil {
+			return syscall.ENOTDIR
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// headObject retrieves the object metadata from S3.
+func (fs *S3FS) headObject(key string) (*s3.HeadObjectOutput, error) {
+	if key == "" {
+		// the root of the bucket is not an object
+		return nil, os.ErrNotExist
+	}
+	ctx, cancel := fs.opts.withTimeout(fs.ctx)
+	defer cancel()
+
+	output, err := fs.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(fs.bucket),
 		Key:    aws.String(key),
 	})
 	if err != nil {
-		if strings.Contains(err.Error(), "NoSuchKey") {
-			return nil, os.ErrNotExist
-		}
-		return nil, err
+		return nil, mapError(err)
+	}
+	return output, nil
+}
+
+// readObject retrieves the object content and user metadata from S3.
+func (fs *S3FS) readObject(key string) ([]byte, map[string]string, error) {
+	if key == "" {
+		return nil, nil, os.ErrNotExist
+	}
+	resp, err := fs.client.GetObject(fs.ctx, &s3.GetObjectInput{
+		Bucket: aws.String(fs.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, nil, mapError(err)
 	}
 	defer resp.Body.Close()
 
-	return io.ReadAll(resp.Body)
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, resp.Metadata, nil
+}
+
+// writeObject uploads b as the object content to S3, along with the user
+// metadata meta. If excl is set, the object is only created if it does not
+// exist yet, otherwise os.ErrExist is returned.
+func (fs *S3FS) writeObject(key string, b []byte, meta map[string]string, excl bool) error {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(fs.bucket),
+		Key:           aws.String(key),
+		Body:          bytes.NewReader(b),
+		ContentLength: aws.Int64(int64(len(b))),
+		Metadata:      meta,
+		StorageClass:  fs.opts.storageClass,
+		ContentType:   fs.contentType(key),
+	}
+	if excl {
+		input.IfNoneMatch = aws.String("*")
+	}
+	_, err := fs.client.PutObject(fs.ctx, input)
+	err = mapError(err)
+	if excl {
+		return existError(err)
+	}
+	return err
+}
+
+// contentType returns the Content-Type of the object key, or nil to leave
+// it to S3.
+func (fs *S3FS) contentType(key string) *string {
+	if fs.opts.contentType == nil {
+		return nil
+	}
+	if ct := fs.opts.contentType(key); ct != "" {
+		return aws.String(ct)
+	}
+	return nil
 }
 
 // Join combines any number of path elements into a single path,
@@ -111,66 +300,313 @@ func (fs *S3FS) Join(elem ...string) string {
 	return path.Join(elem...)
 }
 
-// Remove implements billy.Filesystem.
-func (fs *S3FS) Remove(filename string) error {
-	panic("unimplemented")
+// Remove removes the named file or empty directory from the S3 bucket.
+// A directory is empty if there are no objects under its prefix other than
+// its marker object.
+func (fs *S3FS) Remove(name string) error {
+	if fs.opts.readOnly {
+		return &os.PathError{Op: "remove", Path: name, Err: billy.ErrReadOnly}
+	}
+	key, err := fs.lkey(name)
+	if err != nil {
+		return fs.pathError("remove", name, err)
+	}
+	if key == fs.root {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+
+	_, err = fs.headObject(key)
+	if err == nil {
+		if err := fs.deleteObject(key); err != nil {
+			return fs.pathError("remove", name, err)
+		}
+		return nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fs.pathError("remove", name, err)
+	}
+
+	// not a file, maybe a directory
+	marker := dirPrefix(key)
+	keys, err := fs.listKeys(marker, 2)
+	if err != nil {
+		return fs.pathError("remove", name, err)
+	}
+	if len(keys) == 0 {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	for _, k := range keys {
+		if k != marker {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
+	}
+
+	if err := fs.deleteObject(marker); err != nil {
+		return fs.pathError("remove", name, err)
+	}
+	return nil
 }
 
-// Rename implements billy.Filesystem.
+// deleteObject deletes the object from S3.
+func (fs *S3FS) deleteObject(key string) error {
+	ctx, cancel := fs.opts.withTimeout(fs.ctx)
+	defer cancel()
+
+	_, err := fs.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(fs.bucket),
+		Key:    aws.String(key),
+	})
+	return mapError(err)
+}
+
+// listKeys returns up to max keys of the objects starting with prefix.
+func (fs *S3FS) listKeys(prefix string, max int32) ([]string, error) {
+	ctx, cancel := fs.opts.withTimeout(fs.ctx)
+	defer cancel()
+
+	output, err := fs.client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(fs.bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(max),
+	})
+	if err != nil {
+		return nil, mapError(err)
+	}
+
+	keys := make([]string, 0, len(output.Contents))
+	for _, obj := range output.Contents {
+		keys = append(keys, aws.ToString(obj.Key))
+	}
+	return keys, nil
+}
+
+// Rename moves oldpath to newpath. Objects are copied on the server side
+// and the originals deleted afterwards.
+//
+// A file replaces an existing file at newpath. A directory is renamed by
+// moving every object under its prefix, including its marker object; the
+// destination directory must not exist yet.
 func (fs *S3FS) Rename(oldpath string, newpath string) error {
-	panic("unimplemented")
+	if fs.opts.readOnly {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: billy.ErrReadOnly}
+	}
+	linkErr := func(err error) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.osError("rename", oldpath, err)}
+	}
+	oldKey, err := fs.lkey(oldpath)
+	if err != nil {
+		return linkErr(err)
+	}
+	newKey, err := fs.lkey(newpath)
+	if err != nil {
+		return linkErr(err)
+	}
+	if oldKey == newKey {
+		// nothing to move, but like rename(2), oldpath must exist
+		if _, err := fs.statKey(path.Base(oldKey), oldKey); err != nil {
+			return linkErr(err)
+		}
+		return nil
+	}
+	if oldKey == fs.root || newKey == fs.root {
+		return linkErr(syscall.EBUSY)
+	}
+
+	output, err := fs.headObject(oldKey)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return linkErr(err)
+	}
+	if err == nil {
+		// a file cannot replace a directory
+		keys, err := fs.listKeys(dirPrefix(newKey), 1)
+		if err != nil {
+			return linkErr(err)
+		}
+		if len(keys) > 0 {
+			return linkErr(os.ErrExist)
+		}
+		if err := fs.checkParents(newKey); err != nil {
+			return linkErr(err)
+		}
+
+		if err := fs.copyObject(oldKey, newKey, aws.ToInt64(output.ContentLength), output.StorageClass); err != nil {
+			return linkErr(err)
+		}
+		if err := fs.deleteObject(oldKey); err != nil {
+			return linkErr(err)
+		}
+		return nil
+	}
+
+	if err := fs.renameDir(dirPrefix(oldKey), dirPrefix(newKey)); err != nil {
+		return linkErr(err)
+	}
+	return nil
 }
 
-// Stat retrieves the FileInfo for the named file or directory.
-func (fs *S3FS) Stat(name string) (os.FileInfo, error) {
-	resName, err := fs.underlyingPath(name)
+// renameDir moves all objects under the prefix oldDir to newDir. The copies
+// are removed again if any of them fails, leaving oldDir untouched.
+func (fs *S3FS) renameDir(oldDir, newDir string) error {
+	keys, err := fs.listKeys(oldDir, 1)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if len(keys) == 0 {
+		return os.ErrNotExist
+	}
+	if strings.HasPrefix(newDir, oldDir) {
+		// cannot move a directory into itself
+		return os.ErrInvalid
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	// the destination must be neither a directory nor a file
+	keys, err = fs.listKeys(newDir, 1)
+	if err != nil {
+		return err
+	}
+	if len(keys) > 0 {
+		return os.ErrExist
+	}
+	if _, err := fs.headObject(strings.TrimSuffix(newDir, "/")); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := fs.checkParents(strings.TrimSuffix(newDir, "/")); err != nil {
+		return err
+	}
 
-	input := &s3.HeadObjectInput{
+	var (
+		moved   []string
+		copied  []string
+		copyErr error
+		mu      sync.Mutex // guards copied and copyErr
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, copyConcurrency)
+	)
+	paginator := s3.NewListObjectsV2Paginator(fs.client, &s3.ListObjectsV2Input{
 		Bucket: aws.String(fs.bucket),
-		Key:    aws.String(resName),
+		Prefix: aws.String(oldDir),
+	})
+	for paginator.HasMorePages() && copyErr == nil {
+		ctx, cancel := fs.opts.withTimeout(fs.ctx)
+		page, err := paginator.NextPage(ctx)
+		cancel()
+		if err != nil {
+			copyErr = fmt.Errorf("failed to list objects: %w", mapError(err))
+			break
+		}
+
+		for _, obj := range page.Contents {
+			src := aws.ToString(obj.Key)
+			dst := newDir + strings.TrimPrefix(src, oldDir)
+			size := aws.ToInt64(obj.Size)
+			class := types.StorageClass(obj.StorageClass)
+			moved = append(moved, src)
+
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				err := fs.copyObject(src, dst, size, class)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					if copyErr == nil {
+						copyErr = err
+					}
+					return
+				}
+				copied = append(copied, dst)
+			}()
+		}
+		wg.Wait()
+	}
+
+	if copyErr != nil {
+		// best effort, the original objects are still in place; the copies
+		// are removed even if the context of fs was cancelled
+		cleanup := *fs
+		cleanup.ctx = context.WithoutCancel(fs.ctx)
+		_ = cleanup.deleteKeys(copied)
+		return copyErr
 	}
-	output, err := fs.client.HeadObject(ctx, input)
+	return fs.deleteKeys(moved)
+}
+
+// Stat retrieves the FileInfo for the named file or directory,
+// following symbolic links. Chains of links are followed up to the limit
+// of securejoin, after which Stat fails with syscall.ELOOP.
+func (fs *S3FS) Stat(name string) (os.FileInfo, error) {
+	key, err := fs.key(name)
 	if err != nil {
-		return nil, &os.PathError{
-			Op:   "stat",
-			Path: name,
-			Err:  fmt.Errorf("no such file or directory"),
+		return nil, fs.pathError("stat", name, err)
+	}
+
+	fi, err := fs.statKey(path.Base(name), key)
+	if err != nil {
+		return nil, fs.pathError("stat", name, err)
+	}
+	return fi, nil
+}
+
+// statKey retrieves the FileInfo, named name, of the object key. If there
+// is no such object, key is a directory if there are objects under the
+// prefix "key/", whether it has a marker object or not. Buckets written by
+// other tools usually don't have directory markers. The root is always a
+// directory.
+func (fs *S3FS) statKey(name, key string) (os.FileInfo, error) {
+	output, err := fs.headObject(key)
+	if err == nil {
+		return fileInfo(name, output), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	marker := dirPrefix(key)
+	keys, err := fs.listKeys(marker, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		if key == fs.root {
+			return newDirInfo(name, time.Time{}, nil), nil
 		}
+		return nil, os.ErrNotExist
 	}
-	if _, isSymlink := output.Metadata["Symlink-Target"]; isSymlink {
-		return nil, fmt.Errorf("%w: symlink handling in Stat()", ErrNotImplemented)
+	if keys[0] != marker {
+		// no marker object, no attributes
+		return newDirInfo(name, time.Time{}, nil), nil
 	}
 
-	if strings.HasSuffix(name, "/") {
-		return newDirInfo(path.Base(name)), nil
+	output, err = fs.headObject(marker)
+	if err != nil {
+		return nil, err
 	}
-	return newFileInfo(path.Base(name), *output.ContentLength, *output.LastModified), nil
+	return newDirInfo(name, aws.ToTime(output.LastModified), output.Metadata), nil
 }
 
 // TempFile creates a new temporary file in the directory dir with a name
 // beginning with prefix, opens the file for reading and writing, and
 // returns the resulting *os.File. If dir is the empty string, TempFile
-// uses the default directory for temporary files (see os.TempDir).
+// uses the default directory for temporary files (see TempDir).
 // Multiple programs calling TempFile simultaneously will not choose the
 // same file. The caller can use f.Name() to find the pathname of the file.
 // It is the caller's responsibility to remove the file when no longer
 // needed.
 func (fs *S3FS) TempFile(dir, pattern string) (billy.File, error) {
 	if dir == "" {
-		// TODO: implement fs.TempDir()
-		// dir = fs.TempDir()
-		return nil, fmt.Errorf("%w: TempDir(), path = %q", ErrNotImplemented, pattern)
+		dir = fs.TempDir()
 	}
 
 	prefix, suffix, err := prefixAndSuffix(pattern)
 	if err != nil {
-		return nil, &os.PathError{Op: "tempfile", Path: pattern, Err: err}
+		return nil, fs.pathError("tempfile", pattern, err)
 	}
 	prefix = joinPath(dir, prefix)
 
@@ -179,91 +615,219 @@ func (fs *S3FS) TempFile(dir, pattern string) (billy.File, error) {
 	return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
 }
 
-// ReadDir lists the contents of a directory in the S3 bucket,
-// returning file and directory information.
-func (fs *S3FS) ReadDir(name string) ([]os.FileInfo, error) {
-	cleanName := path.Clean(name)
-	if path.IsAbs(cleanName) {
-		cleanName = cleanName[1:]
+// TempDir returns the default directory for temporary files, relative to
+// the root of the filesystem. See WithTempDir.
+func (fs *S3FS) TempDir() string {
+	return fs.opts.tempDir
+}
+
+// SweepTempDir deletes the objects in the default directory for temporary
+// files that were last modified more than maxAge ago, and returns the
+// number of objects deleted. Temporary files are normally removed by their
+// creator, so this only cleans up after processes that crashed; it is meant
+// to be run periodically, e.g. from a time.Ticker.
+func (fs *S3FS) SweepTempDir(maxAge time.Duration) (int, error) {
+	if fs.opts.readOnly {
+		return 0, billy.ErrReadOnly
+	}
+	key, err := fs.key(fs.TempDir())
+	if err != nil {
+		return 0, err
 	}
+	if key == fs.root {
+		// never sweep the whole filesystem
+		return 0, &os.PathError{Op: "sweep", Path: fs.TempDir(), Err: os.ErrInvalid}
+	}
+	prefix := dirPrefix(key)
+	cutoff := time.Now().Add(-maxAge)
 
-	// Combine with the root path and normalize
-	s3Path := path.Join(fs.root, cleanName)
-	s3Path = strings.TrimLeft(s3Path, "/")
-	if s3Path != "" && !strings.HasSuffix(s3Path, "/") {
-		s3Path += "/"
+	var stale []string
+	paginator := s3.NewListObjectsV2Paginator(fs.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(fs.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		ctx, cancel := fs.opts.withTimeout(fs.ctx)
+		page, err := paginator.NextPage(ctx)
+		cancel()
+		if err != nil {
+			return 0, fs.pathError("sweep", fs.TempDir(), fmt.Errorf("failed to list objects: %w", mapError(err)))
+		}
+		for _, obj := range page.Contents {
+			if aws.ToTime(obj.LastModified).Before(cutoff) {
+				stale = append(stale, aws.ToString(obj.Key))
+			}
+		}
 	}
 
-	// Ensure the path doesn't escape the root
-	base := path.Clean(strings.TrimLeft(fs.root, "/"))
-	target := path.Clean(strings.TrimLeft(path.Join(fs.root, cleanName), "/"))
-	if !isSubPath(base, target) {
-		return nil, fmt.Errorf("invalid path: %s escapes from root", name)
+	if err := fs.deleteKeys(stale); err != nil {
+		return 0, fs.pathError("sweep", fs.TempDir(), err)
 	}
+	return len(stale), nil
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+// ReadDir lists the contents of a directory in the S3 bucket,
+// returning file and directory information.
+func (fs *S3FS) ReadDir(name string) ([]os.FileInfo, error) {
+	key, err := fs.key(name)
+	if err != nil {
+		return nil, fs.pathError("readdir", name, err)
+	}
+	prefix := dirPrefix(key)
 
 	input := &s3.ListObjectsV2Input{
 		Bucket:    aws.String(fs.bucket),
+		Prefix:    aws.String(prefix),
 		Delimiter: aws.String("/"),
 	}
-	if s3Path != "" && s3Path != "/" {
-		input.Prefix = aws.String(s3Path)
-	}
 
-	var results []os.FileInfo
+	var (
+		results []os.FileInfo
+		keys    []string
+		exists  = key == fs.root
+	)
 	paginator := s3.NewListObjectsV2Paginator(fs.client, input)
 	for paginator.HasMorePages() {
+		ctx, cancel := fs.opts.withTimeout(fs.ctx)
 		page, err := paginator.NextPage(ctx)
+		cancel()
 		if err != nil {
-			return nil, fmt.Errorf("failed to list objects: %w", err)
+			return nil, fs.pathError("readdir", name, fmt.Errorf("failed to list objects: %w", mapError(err)))
 		}
-		for _, prefix := range page.CommonPrefixes {
-			dirName := strings.TrimPrefix(aws.ToString(prefix.Prefix), *input.Prefix)
+		// the marker of the directory, if any, is listed too
+		exists = exists || len(page.Contents) > 0 || len(page.CommonPrefixes) > 0
+		for _, p := range page.CommonPrefixes {
+			if aws.ToString(p.Prefix) == dirPrefix(fs.lockDir()) {
+				// hidden, see LockDir
+				continue
+			}
+			dirName := strings.TrimPrefix(aws.ToString(p.Prefix), prefix)
 			dirName = strings.TrimSuffix(dirName, "/")
-			if dirName != "" && dirName != "/" {
-				results = append(results, newDirInfo(dirName))
+			if dirName != "" {
+				results = append(results, newDirInfo(dirName, time.Time{}, nil))
+				keys = append(keys, aws.ToString(p.Prefix))
 			}
 		}
 		for _, obj := range page.Contents {
-			fileName := strings.TrimPrefix(aws.ToString(obj.Key), *input.Prefix)
+			fileName := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
 			if fileName != "" && !strings.HasSuffix(fileName, "/") {
 				results = append(results, newFileInfo(
 					fileName,
 					*obj.Size,
 					*obj.LastModified,
+					nil,
 				))
+				keys = append(keys, aws.ToString(obj.Key))
 			}
 		}
 	}
 
+	if !exists {
+		// like Stat, a directory is a marker or a prefix of objects
+		err := error(os.ErrNotExist)
+		if _, headErr := fs.headObject(key); headErr == nil {
+			err = syscall.ENOTDIR
+		}
+		return nil, fs.pathError("readdir", name, err)
+	}
+
+	if err := fs.readAttrs(results, keys); err != nil {
+		return nil, fs.pathError("readdir", name, err)
+	}
 	return results, nil
 }
 
+// readAttrs replaces every entry of infos with the FileInfo built from the
+// metadata of the object keys[i], which a listing doesn't return. The
+// objects are retrieved in parallel. Directories without marker object
+// and objects deleted since the listing keep their entry.
+func (fs *S3FS) readAttrs(infos []os.FileInfo, keys []string) error {
+	var (
+		mu      sync.Mutex
+		headErr error
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, statConcurrency)
+	)
+	for i := range infos {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			output, err := fs.headObject(keys[i])
+			if errors.Is(err, os.ErrNotExist) {
+				return
+			}
+			if err != nil {
+				mu.Lock()
+				if headErr == nil {
+					headErr = err
+				}
+				mu.Unlock()
+				return
+			}
+
+			if infos[i].IsDir() {
+				infos[i] = newDirInfo(infos[i].Name(), aws.ToTime(output.LastModified), output.Metadata)
+			} else {
+				infos[i] = fileInfo(infos[i].Name(), output)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return headErr
+}
+
 // MkdirAll creates a directory and all necessary parent directories
-// within the S3 bucket. Permissions (perm) are ignored.
+// within the S3 bucket, by writing a marker object for every directory
+// that doesn't have one yet. It fails with syscall.ENOTDIR if a file
+// exists at the path or one of its parents. Permissions (perm) are ignored.
 func (fs *S3FS) MkdirAll(name string, perm os.FileMode) error {
-	resName, err := fs.underlyingPath(name)
+	if fs.opts.readOnly {
+		return &os.PathError{Op: "mkdir", Path: name, Err: billy.ErrReadOnly}
+	}
+	key, err := fs.key(name)
 	if err != nil {
-		return err
+		return fs.pathError("mkdir", name, err)
 	}
 
-	if !strings.HasSuffix(resName, "/") {
-		// Ensure the path ends with a trailing slash to indicate a "directory"
-		resName += "/"
+	rel := relKey(fs.root, key)
+	if rel == "" {
+		// the root always exists
+		return nil
 	}
-	body := strings.NewReader("")
-	_, err = fs.client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(fs.bucket),
-		Key:    aws.String(resName),
-		Body:   body,
-	})
-	if err != nil {
-		return &os.PathError{
-			Op:   "mkdir",
-			Path: name,
-			Err:  fmt.Errorf("failed to create directory in S3 bucket %q: %w", fs.bucket, err),
+
+	var missing []string
+	dir := fs.root
+	for _, elem := range strings.Split(rel, "/") {
+		dir = joinKey(dir, elem)
+
+		_, err := fs.headObject(dir)
+		if err == nil {
+			return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return fs.pathError("mkdir", name, err)
+		}
+
+		marker := dirPrefix(dir)
+		_, err = fs.headObject(marker)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return fs.pathError("mkdir", name, err)
+		}
+		missing = append(missing, marker)
+	}
+
+	for _, marker := range missing {
+		if err := fs.writeObject(marker, nil, nil, false); err != nil {
+			return fs.pathError("mkdir", name, fmt.Errorf("failed to create directory in S3 bucket %q: %w", fs.bucket, err))
 		}
 	}
 
@@ -273,48 +837,68 @@ func (fs *S3FS) MkdirAll(name string, perm os.FileMode) error {
 // Lstat retrieves the FileInfo for the named file or directory
 // without following symbolic links.
 func (fs *S3FS) Lstat(name string) (os.FileInfo, error) {
-	resName, err := fs.underlyingPath(name)
+	key, err := fs.lkey(name)
 	if err != nil {
-		return nil, err
+		return nil, fs.pathError("lstat", name, err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	fi, err := fs.statKey(path.Base(name), key)
+	if err != nil {
+		return nil, fs.pathError("lstat", name, err)
+	}
+	return fi, nil
+}
 
-	input := &s3.HeadObjectInput{
-		Bucket: aws.String(fs.bucket),
-		Key:    aws.String(resName),
+// Symlink creates link as a symbolic link to target in the S3 bucket.
+// The link is stored as an empty object with the target in its metadata.
+// Like OpenFile, it fails if a directory was created at link with
+// MkdirAll, with os.ErrExist, and with syscall.ENOTDIR if one of the
+// parents of link is a file.
+func (fs *S3FS) Symlink(target string, link string) error {
+	if fs.opts.readOnly {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: billy.ErrReadOnly}
 	}
-	output, err := fs.client.HeadObject(ctx, input)
+	key, err := fs.lkey(link)
 	if err != nil {
-		return nil, &os.PathError{
-			Op:   "lstat",
-			Path: name,
-			Err:  fmt.Errorf("no such file or directory"),
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: fs.osError("symlink", link, err)}
+	}
+	if err := fs.checkCreate(key); err != nil {
+		if errors.Is(err, syscall.EISDIR) {
+			err = os.ErrExist
 		}
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: fs.osError("symlink", link, err)}
 	}
 
-	if strings.HasSuffix(name, "/") {
-		return newDirInfo(path.Base(name)), nil
+	meta := map[string]string{metaSymlinkTarget: target}
+	if err := fs.writeObject(key, nil, meta, true); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: link, Err: fs.osError("symlink", link, err)}
 	}
-	return newFileInfo(path.Base(name), *output.ContentLength, *output.LastModified), nil
-}
-
-// Symlink creates newname as a symbolic link to oldname in the S3 bucket.
-func (fs *S3FS) Symlink(oldname string, newname string) error {
-	return fmt.Errorf("%w: Symlink()", ErrNotImplemented)
+	return nil
 }
 
 // Readlink returns the destination of the named symbolic link
 // in the S3 bucket.
 func (fs *S3FS) Readlink(name string) (string, error) {
-	return "", fmt.Errorf("%w: Readlink()", ErrNotImplemented)
+	key, err := fs.lkey(name)
+	if err != nil {
+		return "", fs.pathError("readlink", name, err)
+	}
+
+	output, err := fs.headObject(key)
+	if err != nil {
+		return "", fs.pathError("readlink", name, err)
+	}
+	target, ok := symlinkTarget(output.Metadata)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return target, nil
 }
 
 // Chroot scopes the S3FS to a subdirectory and returns a new S3FS instance
 // rooted at the given path.
 func (fs *S3FS) Chroot(subPath string) (billy.Filesystem, error) {
-	newRoot, err := fs.underlyingPath(subPath)
+	newRoot, err := fs.key(subPath)
 	if err != nil {
 		return nil, err
 	}
@@ -323,26 +907,44 @@ func (fs *S3FS) Chroot(subPath string) (billy.Filesystem, error) {
 		client: fs.client,
 		bucket: fs.bucket,
 		root:   newRoot,
+		ctx:    fs.ctx,
+		opts:   fs.opts,
 	}, nil
 }
 
-// Root returns the root path of the filesystem.
-func (fs *S3FS) Root() string {
-	return fs.root
+// WithContext returns a view of the filesystem whose S3 requests, and
+// those of the files it opens, use ctx. Once ctx is cancelled or its
+// deadline is exceeded, operations fail with a *os.PathError wrapping
+// context.Canceled or context.DeadlineExceeded. The timeout of metadata
+// requests (see WithTimeout) still applies within ctx.
+//
+// The returned filesystem shares its root and configuration with fs;
+// Chroot on it keeps ctx.
+func (fs *S3FS) WithContext(ctx context.Context) billy.Filesystem {
+	if ctx == nil {
+		panic("s3fs: nil context")
+	}
+	view := *fs
+	view.ctx = ctx
+	return &view
 }
 
-// underlyingPath ensures the given path is within the allowed boundaries
-// and resolves it relative to the current root.
-func (fs *S3FS) underlyingPath(p string) (string, error) {
-	// TODO: use abs() instead of underlyingPath()
-	if isCrossBoundaries(p) {
-		return "", billy.ErrCrossedBoundary
+var _ billy.Capable = (*S3FS)(nil)
+
+// Capabilities implements billy.Capable. A read-only filesystem (see
+// WithReadOnly) can only be read and seeked, and its files cannot be
+// locked.
+func (fs *S3FS) Capabilities() billy.Capability {
+	if fs.opts.readOnly {
+		return billy.ReadCapability | billy.SeekCapability
 	}
-	return path.Join(fs.root, path.Clean(p)), nil
+	return billy.WriteCapability | billy.ReadCapability |
+		billy.ReadAndWriteCapability | billy.SeekCapability |
+		billy.TruncateCapability | billy.LockCapability
 }
 
-// isCrossBoundaries checks if the given S3 path escapes boundaries.
-func isCrossBoundaries(p string) bool {
-	p1 := path.Clean(p)
-	return strings.HasPrefix(p1, "../")
+// Root returns the root path of the filesystem, which is the key prefix
+// of its objects with a leading slash, e.g. "/" or "/repos/project".
+func (fs *S3FS) Root() string {
+	return "/" + fs.root
 }
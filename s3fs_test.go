@@ -1,10 +1,24 @@
 package s3fs
 
 import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
 	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/gurza/go-billy-s3fs/s3fstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestS3FS_ImplementsBillyFilesystem(t *testing.T) {
@@ -18,3 +32,715 @@ func TestS3FS_ImplementsBillyFilesystem(t *testing.T) {
 		t.Errorf("S3FS does not implement billy.Filesystem interface")
 	}
 }
+
+func TestS3FS_WithContext(t *testing.T) {
+	client := s3.New(s3.Options{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+	})
+	fsys, err := New(client, "bucket")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	view := fsys.(*S3FS).WithContext(ctx)
+	assert.Equal(t, context.Background(), fsys.(*S3FS).ctx)
+
+	// requests fail before anything is sent
+	var pathErr *os.PathError
+	_, err = view.Stat("file")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorAs(t, err, &pathErr)
+	_, err = view.ReadDir("dir")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorAs(t, err, &pathErr)
+
+	// the context is kept by Chroot and by open files
+	_, err = view.Chroot("sub")
+	assert.ErrorIs(t, err, context.Canceled)
+	sub, err := view.Chroot("/")
+	require.NoError(t, err)
+	assert.Equal(t, ctx, sub.(*S3FS).ctx)
+	_, err = sub.Open("file")
+	assert.ErrorIs(t, err, context.Canceled)
+	f := newRangeFile(sub.(*S3FS), "file", "file", nil, 10)
+	_, err = f.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.ErrorAs(t, err, &pathErr)
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = fsys.(*S3FS).WithContext(ctx).Lstat("file")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorAs(t, err, &pathErr)
+}
+
+// cancelingClient is an S3API that cancels a context once the nth request
+// of the operation op has been answered, or before it is sent if n is
+// negative, like a caller giving up midway.
+type cancelingClient struct {
+	S3API
+	op     string
+	n      int32
+	cancel context.CancelFunc
+	sent   atomic.Int32
+}
+
+func (c *cancelingClient) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	defer c.send("ListObjectsV2")()
+	return c.S3API.ListObjectsV2(ctx, params, optFns...)
+}
+
+func (c *cancelingClient) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	defer c.send("CopyObject")()
+	return c.S3API.CopyObject(ctx, params, optFns...)
+}
+
+func (c *cancelingClient) send(op string) (answered func()) {
+	if op != c.op {
+		return func() {}
+	}
+	n := c.sent.Add(1)
+	if n == -c.n {
+		c.cancel()
+	}
+	return func() {
+		if n == c.n {
+			c.cancel()
+		}
+	}
+}
+
+func TestS3FS_WithContext_Deletes(t *testing.T) {
+	keys := []string{".tmp/old", "dir/a", "dir/b"}
+
+	ops := map[string]func(fs *S3FS) error{
+		"removeall": func(fs *S3FS) error { return fs.RemoveAll("dir") },
+		"rename":    func(fs *S3FS) error { return fs.Rename("dir", "moved") },
+		"sweep": func(fs *S3FS) error {
+			n, err := fs.SweepTempDir(0)
+			assert.Zero(t, n)
+			return err
+		},
+	}
+	tests := []struct {
+		name string
+		op   string // see cancelingClient, cancelled upfront if empty
+		n    int32
+		fn   string
+		want []string
+	}{
+		{"removeall cancelled", "", 0, "removeall", keys},
+		{"rename cancelled", "", 0, "rename", keys},
+		{"sweep cancelled", "", 0, "sweep", keys},
+		{"removeall after listing", "ListObjectsV2", 1, "removeall", keys},
+		{"sweep after listing", "ListObjectsV2", 1, "sweep", keys},
+		// the copies made so far are removed again
+		{"rename during copies", "CopyObject", -2, "rename", keys},
+		// the originals are kept when they can't be deleted
+		{"rename after copies", "CopyObject", 2, "rename", []string{".tmp/old", "dir/a", "dir/b", "moved/a", "moved/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := s3fstest.NewClient("bucket")
+			for _, key := range keys {
+				_, err := c.PutObject(context.Background(), &s3.PutObjectInput{
+					Bucket: aws.String("bucket"),
+					Key:    aws.String(key),
+					Body:   strings.NewReader(""),
+				})
+				require.NoError(t, err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.op == "" {
+				cancel()
+			}
+			base, err := New(&cancelingClient{S3API: c, op: tt.op, n: tt.n, cancel: cancel}, "bucket")
+			require.NoError(t, err)
+
+			err = ops[tt.fn](base.(*S3FS).WithContext(ctx).(*S3FS))
+			assert.ErrorIs(t, err, context.Canceled)
+			var pathErr *os.PathError
+			var linkErr *os.LinkError
+			assert.True(t, errors.As(err, &pathErr) || errors.As(err, &linkErr), "%v", err)
+			assert.Equal(t, tt.want, c.Keys("bucket"))
+		})
+	}
+}
+
+func TestS3FS_ImplementedByFake(t *testing.T) {
+	var client s3fstest.Client
+
+	iface := reflect.TypeOf((*S3API)(nil)).Elem()
+	sType := reflect.TypeOf(&client)
+
+	if !sType.Implements(iface) {
+		t.Errorf("*s3fstest.Client does not implement S3API interface")
+	}
+}
+
+// newTestFS returns a filesystem backed by an in-memory bucket named
+// "bucket".
+func newTestFS(t *testing.T, opts ...Option) (*S3FS, *s3fstest.Client) {
+	t.Helper()
+	client := s3fstest.NewClient("bucket")
+	fsys, err := New(client, "bucket", opts...)
+	require.NoError(t, err)
+	return fsys.(*S3FS), client
+}
+
+func TestS3FS_CreateAndRead(t *testing.T) {
+	fsys, client := newTestFS(t)
+
+	require.NoError(t, util.WriteFile(fsys, "/dir/file.txt", []byte("hello"), 0640))
+	assert.Equal(t, []string{"dir/file.txt"}, client.Keys("bucket"))
+
+	b, err := util.ReadFile(fsys, "dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	fi, err := fsys.Stat("dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "file.txt", fi.Name())
+	assert.Equal(t, int64(5), fi.Size())
+	assert.Equal(t, os.FileMode(0640), fi.Mode())
+
+	fi, err = fsys.Stat("dir")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+
+	_, err = fsys.Stat("missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = fsys.Open("missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// read-write opens keep the content
+	f, err := fsys.OpenFile("dir/file.txt", os.O_RDWR|os.O_APPEND, 0)
+	require.NoError(t, err)
+	_, err = f.Write([]byte(" world"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	b, err = util.ReadFile(fsys, "dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", string(b))
+}
+
+func TestS3FS_OpenFile_Exclusive(t *testing.T) {
+	fsys, _ := newTestFS(t)
+
+	require.NoError(t, util.WriteFile(fsys, "file", []byte("a"), 0644))
+	_, err := fsys.OpenFile("file", os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	assert.ErrorIs(t, err, os.ErrExist)
+
+	// links are not followed, even dangling ones
+	require.NoError(t, fsys.Symlink("target", "dang"))
+	require.NoError(t, fsys.Symlink("file", "lnk"))
+	for _, flag := range []int{os.O_RDWR | os.O_CREATE | os.O_EXCL, os.O_WRONLY | os.O_CREATE | os.O_EXCL} {
+		_, err = fsys.OpenFile("dang", flag, 0644)
+		assert.ErrorIs(t, err, os.ErrExist, "flag %#x", flag)
+		_, err = fsys.OpenFile("lnk", flag, 0644)
+		assert.ErrorIs(t, err, os.ErrExist, "flag %#x", flag)
+	}
+	_, err = fsys.Lstat("target")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// the first of two concurrent writers wins
+	f1, err := fsys.OpenFile("new", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	require.NoError(t, err)
+	f2, err := fsys.OpenFile("new", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	require.NoError(t, err)
+	require.NoError(t, f1.Close())
+	assert.ErrorIs(t, f2.Close(), os.ErrExist)
+}
+
+func TestS3FS_OpenFile_Conflicts(t *testing.T) {
+	fsys, client := newTestFS(t)
+
+	require.NoError(t, fsys.MkdirAll("dir", 0755))
+	require.NoError(t, util.WriteFile(fsys, "file", []byte("data"), 0644))
+
+	for _, flag := range []int{os.O_RDWR | os.O_CREATE, os.O_WRONLY | os.O_CREATE | os.O_TRUNC, os.O_RDWR | os.O_CREATE | os.O_EXCL} {
+		_, err := fsys.OpenFile("dir", flag, 0644)
+		assert.ErrorIs(t, err, syscall.EISDIR, "flag %#x", flag)
+		_, err = fsys.OpenFile("/", flag, 0644)
+		assert.ErrorIs(t, err, syscall.EISDIR, "flag %#x", flag)
+		_, err = fsys.OpenFile("file/a", flag, 0644)
+		assert.ErrorIs(t, err, syscall.ENOTDIR, "flag %#x", flag)
+		_, err = fsys.OpenFile("file/a/b", flag, 0644)
+		assert.ErrorIs(t, err, syscall.ENOTDIR, "flag %#x", flag)
+	}
+	assert.ErrorIs(t, util.WriteFile(fsys, "dir", []byte("data"), 0644), syscall.EISDIR)
+	assert.Equal(t, []string{"dir/", "file"}, client.Keys("bucket"))
+
+	fi, err := fsys.Stat("dir")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+
+	// flags S3 can't honour
+	_, err = fsys.OpenFile("file", os.O_RDWR|os.O_SYNC, 0644)
+	var pathErr *os.PathError
+	require.ErrorAs(t, err, &pathErr)
+	assert.ErrorIs(t, err, ErrNotImplemented)
+
+	// an existing file is opened as usual
+	require.NoError(t, util.WriteFile(fsys, "file", []byte("new"), 0644))
+	require.NoError(t, util.WriteFile(fsys, "dir/file", []byte("new"), 0644))
+}
+
+func TestS3FS_Stat_ImplicitDir(t *testing.T) {
+	fsys, client := newTestFS(t)
+
+	// written by another tool, without directory markers
+	_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("logs/2024/a.log"),
+		Body:   strings.NewReader("data"),
+	})
+	require.NoError(t, err)
+
+	for _, name := range []string{"logs", "/logs/", "logs/2024"} {
+		fi, err := fsys.Stat(name)
+		require.NoError(t, err, name)
+		assert.True(t, fi.IsDir(), name)
+		assert.Equal(t, os.ModeDir|0755, fi.Mode(), name)
+		assert.True(t, fi.ModTime().IsZero(), name)
+		fi, err = fsys.Lstat(name)
+		require.NoError(t, err, name)
+		assert.True(t, fi.IsDir(), name)
+	}
+	fi, err := fsys.Stat("logs/2024")
+	require.NoError(t, err)
+	assert.Equal(t, "2024", fi.Name())
+
+	// only whole path elements are directories
+	for _, name := range []string{"log", "logs/20", "logs/2024/a"} {
+		_, err := fsys.Stat(name)
+		assert.ErrorIs(t, err, os.ErrNotExist, name)
+		_, err = fsys.Lstat(name)
+		assert.ErrorIs(t, err, os.ErrNotExist, name)
+	}
+
+	// a marker, once there is one, keeps the attributes
+	require.NoError(t, fsys.MkdirAll("logs", 0755))
+	require.NoError(t, fsys.Chmod("logs", 0700))
+	fi, err = fsys.Stat("logs")
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0700, fi.Mode())
+	assert.False(t, fi.ModTime().IsZero())
+}
+
+func TestS3FS_ReadDir(t *testing.T) {
+	fsys, _ := newTestFS(t)
+
+	require.NoError(t, util.WriteFile(fsys, "dir/a", []byte("a"), 0600))
+	require.NoError(t, util.WriteFile(fsys, "dir/sub/b", []byte("bb"), 0644))
+	require.NoError(t, fsys.MkdirAll("dir/empty", 0755))
+	require.NoError(t, util.WriteFile(fsys, "other", nil, 0644))
+
+	infos, err := fsys.ReadDir("/dir/")
+	require.NoError(t, err)
+	entries := map[string]os.FileInfo{}
+	for _, fi := range infos {
+		entries[fi.Name()] = fi
+	}
+	require.Len(t, entries, 3)
+	assert.Equal(t, os.FileMode(0600), entries["a"].Mode())
+	assert.True(t, entries["sub"].IsDir())
+	assert.True(t, entries["empty"].IsDir())
+
+	infos, err = fsys.ReadDir("/")
+	require.NoError(t, err)
+	assert.Len(t, infos, 2)
+
+	_, err = fsys.ReadDir("missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = fsys.ReadDir("other")
+	assert.ErrorIs(t, err, syscall.ENOTDIR)
+
+	_, err = fsys.ReadDir("../dir")
+	assert.ErrorIs(t, err, billy.ErrCrossedBoundary)
+}
+
+func TestS3FS_MkdirAll(t *testing.T) {
+	fsys, client := newTestFS(t)
+
+	require.NoError(t, fsys.MkdirAll("a/b/c", 0755))
+	assert.Equal(t, []string{"a/", "a/b/", "a/b/c/"}, client.Keys("bucket"))
+	require.NoError(t, fsys.MkdirAll("/", 0755))
+	require.NoError(t, fsys.MkdirAll("a/b/d", 0755))
+	assert.Equal(t, []string{"a/", "a/b/", "a/b/c/", "a/b/d/"}, client.Keys("bucket"))
+
+	// existing markers are not written again
+	require.NoError(t, fsys.Chmod("a/b", 0700))
+	require.NoError(t, fsys.MkdirAll("a/b/c", 0755))
+	fi, err := fsys.Stat("a/b")
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeDir|0700, fi.Mode())
+
+	// a file can't be a directory, nor hold one
+	require.NoError(t, util.WriteFile(fsys, "a/file", nil, 0644))
+	err = fsys.MkdirAll("a/file", 0755)
+	assert.ErrorIs(t, err, syscall.ENOTDIR)
+	var pathErr *os.PathError
+	require.ErrorAs(t, err, &pathErr)
+	assert.Equal(t, "a/file", pathErr.Path)
+	assert.ErrorIs(t, fsys.MkdirAll("a/file/c", 0755), syscall.ENOTDIR)
+	assert.ErrorIs(t, fsys.MkdirAll("a/file/c/d", 0755), syscall.ENOTDIR)
+	assert.Equal(t, []string{"a/", "a/b/", "a/b/c/", "a/b/d/", "a/file"}, client.Keys("bucket"))
+
+	assert.ErrorIs(t, fsys.MkdirAll("../x", 0755), billy.ErrCrossedBoundary)
+}
+
+func TestS3FS_Remove(t *testing.T) {
+	fsys, client := newTestFS(t)
+
+	require.NoError(t, util.WriteFile(fsys, "file", nil, 0644))
+	require.NoError(t, fsys.Remove("file"))
+	require.NoError(t, fsys.MkdirAll("empty", 0755))
+	require.NoError(t, fsys.Remove("empty/"))
+	assert.Empty(t, client.Keys("bucket"))
+
+	// a directory holds objects, with or without marker
+	require.NoError(t, util.WriteFile(fsys, "dir/file", nil, 0644))
+	_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("implicit/file"),
+		Body:   strings.NewReader("data"),
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, fsys.Remove("dir"), syscall.ENOTEMPTY)
+	assert.ErrorIs(t, fsys.Remove("implicit"), syscall.ENOTEMPTY)
+
+	err = fsys.Remove("missing")
+	assert.True(t, os.IsNotExist(err), "%v", err)
+	assert.ErrorIs(t, fsys.Remove("dir/file/missing"), os.ErrNotExist)
+
+	// a link is removed, not its target
+	require.NoError(t, fsys.Symlink("dir/file", "lnk"))
+	require.NoError(t, fsys.Remove("lnk"))
+	assert.Equal(t, []string{"dir/file", "implicit/file"}, client.Keys("bucket"))
+
+	// paths are confined to the root
+	sub, err := fsys.Chroot("dir")
+	require.NoError(t, err)
+	assert.ErrorIs(t, sub.Remove("../implicit/file"), billy.ErrCrossedBoundary)
+	assert.ErrorIs(t, sub.Remove("/"), syscall.EBUSY)
+	require.NoError(t, sub.Remove("/file"))
+	assert.Equal(t, []string{"implicit/file"}, client.Keys("bucket"))
+}
+
+func TestS3FS_RemoveAll(t *testing.T) {
+	fsys, client := newTestFS(t)
+
+	require.NoError(t, util.WriteFile(fsys, "dir/a", nil, 0644))
+	require.NoError(t, util.WriteFile(fsys, "dir/sub/b", nil, 0644))
+	require.NoError(t, util.WriteFile(fsys, "dir.txt", nil, 0644))
+	require.NoError(t, fsys.RemoveAll("dir"))
+	assert.Equal(t, []string{"dir.txt"}, client.Keys("bucket"))
+	require.NoError(t, fsys.RemoveAll("dir.txt"))
+	require.NoError(t, fsys.RemoveAll("missing"))
+
+	// the root, of the bucket or of a chroot, is never removed
+	require.NoError(t, util.WriteFile(fsys, "file", nil, 0644))
+	require.NoError(t, fsys.MkdirAll("chroot", 0755))
+	sub, err := fsys.Chroot("chroot")
+	require.NoError(t, err)
+	for _, name := range []string{"/", "", ".", "a/.."} {
+		assert.ErrorIs(t, fsys.RemoveAll(name), syscall.EBUSY, name)
+		assert.ErrorIs(t, sub.(*S3FS).RemoveAll(name), syscall.EBUSY, name)
+	}
+	assert.Equal(t, []string{"chroot/", "file"}, client.Keys("bucket"))
+}
+
+func TestS3FS_Rename(t *testing.T) {
+	fsys, client := newTestFS(t)
+
+	require.NoError(t, util.WriteFile(fsys, "file", []byte("data"), 0600))
+	require.NoError(t, fsys.Rename("file", "moved"))
+	assert.Equal(t, []string{"moved"}, client.Keys("bucket"))
+	fi, err := fsys.Stat("moved")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode())
+
+	require.NoError(t, util.WriteFile(fsys, "dir/a", []byte("a"), 0644))
+	require.NoError(t, util.WriteFile(fsys, "dir/sub/b", []byte("b"), 0644))
+	require.NoError(t, fsys.Rename("dir", "new/dir"))
+	assert.Equal(t, []string{"moved", "new/dir/a", "new/dir/sub/b"}, client.Keys("bucket"))
+
+	assert.ErrorIs(t, fsys.Rename("new", "new/dir/inside"), os.ErrInvalid)
+	assert.ErrorIs(t, fsys.Rename("moved", "new"), os.ErrExist)
+	assert.ErrorIs(t, fsys.Rename("missing", "other"), os.ErrNotExist)
+	assert.ErrorIs(t, fsys.Rename("missing", "missing"), os.ErrNotExist)
+	assert.ErrorIs(t, fsys.Rename("missing", "missing/inside"), os.ErrNotExist)
+	assert.ErrorIs(t, fsys.Rename("new/dir/a", "moved/a"), syscall.ENOTDIR)
+	assert.ErrorIs(t, fsys.Rename("new/dir", "moved/dir"), syscall.ENOTDIR)
+	require.NoError(t, fsys.Rename("moved", "moved"))
+}
+
+func TestS3FS_Symlink(t *testing.T) {
+	fsys, _ := newTestFS(t)
+
+	require.NoError(t, util.WriteFile(fsys, "dir/file", []byte("data"), 0644))
+	require.NoError(t, fsys.Symlink("/dir", "lnk"))
+	assert.ErrorIs(t, fsys.Symlink("/dir", "lnk"), os.ErrExist)
+
+	// nor over a directory or under a file
+	require.NoError(t, fsys.MkdirAll("empty", 0755))
+	assert.ErrorIs(t, fsys.Symlink("x", "empty"), os.ErrExist)
+	assert.ErrorIs(t, fsys.Symlink("x", "/"), os.ErrExist)
+	assert.ErrorIs(t, fsys.Symlink("x", "dir/file/l"), syscall.ENOTDIR)
+	fi, err := fsys.Lstat("empty")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	_, err = fsys.Lstat("dir/file/l")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	target, err := fsys.Readlink("lnk")
+	require.NoError(t, err)
+	assert.Equal(t, "/dir", target)
+
+	fi, err = fsys.Lstat("lnk")
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSymlink, fi.Mode()&os.ModeType)
+	fi, err = fsys.Stat("lnk")
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+
+	b, err := util.ReadFile(fsys, "lnk/file")
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(b))
+
+	// links cannot escape the root of a chroot
+	sub, err := fsys.Chroot("dir")
+	require.NoError(t, err)
+	require.NoError(t, sub.Symlink("../../dir", "up"))
+	_, err = sub.Stat("up/file")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestS3FS_Chroot(t *testing.T) {
+	fsys, client := newTestFS(t, WithPrefix("/repos/"))
+
+	sub, err := fsys.Chroot("project")
+	require.NoError(t, err)
+	assert.Equal(t, "/repos/project", sub.Root())
+	require.NoError(t, util.WriteFile(sub, "HEAD", []byte("ref"), 0644))
+	assert.Equal(t, []string{"repos/project/HEAD"}, client.Keys("bucket"))
+
+	// what is written in a chroot is found from its parent, and back
+	_, err = fsys.Stat("project/HEAD")
+	require.NoError(t, err)
+	infos, err := sub.ReadDir("/")
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "HEAD", infos[0].Name())
+
+	_, err = sub.Stat("../project/HEAD")
+	assert.ErrorIs(t, err, billy.ErrCrossedBoundary)
+}
+
+// keyRecorder is an S3API recording the object keys, and the listed
+// prefixes, of the requests sent through it.
+type keyRecorder struct {
+	S3API
+	bucket string
+	keys   []string
+}
+
+func (r *keyRecorder) record(key *string) {
+	r.keys = append(r.keys, aws.ToString(key))
+}
+
+// recordSource records the key of a CopySource, see copySource.
+func (r *keyRecorder) recordSource(source *string) {
+	key, err := url.PathUnescape(strings.TrimPrefix(aws.ToString(source), r.bucket+"/"))
+	if err != nil {
+		key = aws.ToString(source)
+	}
+	r.keys = append(r.keys, key)
+}
+
+func (r *keyRecorder) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	r.record(params.Key)
+	return r.S3API.GetObject(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	r.record(params.Key)
+	return r.S3API.HeadObject(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	r.record(params.Key)
+	return r.S3API.PutObject(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	r.record(params.Key)
+	r.recordSource(params.CopySource)
+	return r.S3API.CopyObject(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	r.record(params.Key)
+	return r.S3API.DeleteObject(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	if params.Delete != nil {
+		for _, o := range params.Delete.Objects {
+			r.record(o.Key)
+		}
+	}
+	return r.S3API.DeleteObjects(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	// a directory is listed by its prefix, see dirPrefix
+	r.keys = append(r.keys, strings.TrimSuffix(aws.ToString(params.Prefix), "/"))
+	return r.S3API.ListObjectsV2(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	r.record(params.Key)
+	return r.S3API.CreateMultipartUpload(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	r.record(params.Key)
+	return r.S3API.UploadPart(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) UploadPartCopy(ctx context.Context, params *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	r.record(params.Key)
+	r.recordSource(params.CopySource)
+	return r.S3API.UploadPartCopy(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	r.record(params.Key)
+	return r.S3API.CompleteMultipartUpload(ctx, params, optFns...)
+}
+
+func (r *keyRecorder) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	r.record(params.Key)
+	return r.S3API.AbortMultipartUpload(ctx, params, optFns...)
+}
+
+// FuzzS3FS_Confinement checks that, whatever the names and the targets of
+// links, nested chroots of a prefixed filesystem stay under the prefix, and
+// OpenFile, Stat, ReadDir, MkdirAll and Chroot in the innermost one only
+// send keys under its root.
+func FuzzS3FS_Confinement(f *testing.F) {
+	for _, seed := range []struct {
+		dir, sub, name, target string
+	}{
+		{"dir", "sub", "file", "/"},
+		{"..", "sub", "file", ""},
+		{"dir", "../..", "../file", ".."},
+		{"dir", "sub", "..%2F..%2Ffile", ""},
+		{"dir%2f..", "sub", "a%2f..%2f..", ""},
+		{"dir", "sub", "a\x00/../../..", "\x00"},
+		{"lnk", "sub", "lnk/../../file", "../../.."},
+		{"dir", "lnk", "lnk", "/../other"},
+		{"dir", "sub", "lnk/..", "lnk"},
+		{"lnk", "lnk", "lnk/lnk", "/lnk/.."},
+		{"dir", "sub", "..file", "..dir"},
+	} {
+		f.Add(seed.dir, seed.sub, seed.name, seed.target)
+	}
+	f.Fuzz(func(t *testing.T, dir, sub, name, target string) {
+		client := &keyRecorder{S3API: s3fstest.NewClient("bucket"), bucket: "bucket"}
+		fsys, err := New(client, "bucket", WithPrefix("base"))
+		require.NoError(t, err)
+
+		// a link to target at every level; Chroot follows links too
+		_ = fsys.Symlink(target, "lnk")
+		outer, err := fsys.Chroot(dir)
+		if err != nil {
+			return
+		}
+		_ = outer.Symlink(target, "lnk")
+		inner, err := outer.Chroot(sub)
+		if err != nil {
+			return
+		}
+		_ = inner.Symlink(target, "lnk")
+
+		outerRoot, root := outer.(*S3FS).root, inner.(*S3FS).root
+		require.True(t, outerRoot == "base" || strings.HasPrefix(outerRoot, "base/"), "chroot %q: %q", dir, outerRoot)
+		require.True(t, root == outerRoot || strings.HasPrefix(root, dirPrefix(outerRoot)), "chroot %q in %q: %q", sub, dir, root)
+
+		client.keys = nil
+		for _, name := range []string{name, "lnk/" + name} {
+			if f, err := inner.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644); err == nil {
+				_, _ = f.Write([]byte("data"))
+				_ = f.Close()
+			}
+			_, _ = inner.Stat(name)
+			_, _ = inner.ReadDir(name)
+			_ = inner.MkdirAll(name, 0755)
+			if nested, err := inner.Chroot(name); err == nil {
+				_, _ = nested.Stat(name)
+				_, _ = nested.ReadDir("/")
+			}
+		}
+		for _, key := range client.keys {
+			require.True(t, key == root || strings.HasPrefix(key, dirPrefix(root)), "%q in chroot %q of %q: %q", name, sub, dir, key)
+		}
+	})
+}
+
+func TestS3FS_TempFileAndSweep(t *testing.T) {
+	fsys, client := newTestFS(t)
+
+	f, err := fsys.TempFile("", "pack-*.tmp")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.True(t, strings.HasPrefix(f.Name(), ".tmp/pack-"))
+	assert.Len(t, client.Keys("bucket"), 1)
+
+	n, err := fsys.SweepTempDir(time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	n, err = fsys.SweepTempDir(-time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Empty(t, client.Keys("bucket"))
+}
+
+func TestS3FS_Server(t *testing.T) {
+	client, bucket := s3fstest.NewServer(t)
+	fsys, err := New(client, bucket, WithPartSize(minPartSize))
+	require.NoError(t, err)
+
+	require.NoError(t, util.WriteFile(fsys, "dir/small", []byte("hello"), 0640))
+	data := []byte(strings.Repeat("0123456789abcdef", (minPartSize+1024)/16))
+	f, err := fsys.OpenFile("dir/big", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	require.NoError(t, err)
+	_, err = f.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	got, err := util.ReadFile(fsys, "dir/big")
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	require.NoError(t, fsys.Rename("dir/small", "dir/renamed"))
+	entries, err := fsys.ReadDir("dir")
+	require.NoError(t, err)
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"big", "renamed"}, names)
+
+	fi, err := fsys.Stat("dir/renamed")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+
+	_, err = fsys.Stat("dir/small")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,758 @@
+// Package s3fstest provides an in-memory implementation of the S3 API used
+// by s3fs, to test code built on s3fs without network access:
+//
+//	client := s3fstest.NewClient("bucket")
+//	fsys, err := s3fs.New(client, "bucket")
+//
+// The Client models the parts of S3 that s3fs relies on: objects with user
+// metadata and ETags, listings with prefixes, delimiters and pagination,
+// multipart uploads, conditional requests, byte ranges and versioning.
+// Errors have the same types and codes as those returned by the SDK for
+// real responses, e.g. *types.NoSuchKey, or a smithy.APIError with the
+// code "PreconditionFailed".
+//
+// NewServer serves a Client over HTTP instead, to exercise the whole
+// request path of the SDK:
+//
+//	client, bucket := s3fstest.NewServer(t)
+//	fsys, err := s3fs.New(client, bucket)
+package s3fstest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// maxKeys is the largest number of keys returned by a single listing.
+const maxKeys = 1000
+
+// Client is an in-memory S3 client. Its methods have the signatures of
+// those of *s3.Client and are safe for concurrent use. The options of the
+// SDK (optFns) are ignored.
+type Client struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	seq     int // source of version and upload IDs
+}
+
+type bucket struct {
+	// versions of the objects by key, oldest first, including delete
+	// markers; without versioning, only the current one is kept
+	objects    map[string][]*object
+	versioning bool
+	uploads    map[string]*upload
+}
+
+type object struct {
+	key          string
+	versionID    string
+	data         []byte
+	etag         string
+	modified     time.Time
+	meta         map[string]string
+	header       header
+	storageClass types.StorageClass
+	deleteMarker bool
+}
+
+// header holds the standard headers stored with an object.
+type header struct {
+	cacheControl       *string
+	contentDisposition *string
+	contentEncoding    *string
+	contentLanguage    *string
+	contentType        *string
+}
+
+// NewClient returns a Client with the given empty buckets.
+func NewClient(buckets ...string) *Client {
+	c := &Client{buckets: make(map[string]*bucket)}
+	for _, name := range buckets {
+		c.CreateBucket(name)
+	}
+	return c
+}
+
+// CreateBucket creates an empty bucket, unless it already exists.
+func (c *Client) CreateBucket(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.buckets[name]; !ok {
+		c.buckets[name] = &bucket{
+			objects: make(map[string][]*object),
+			uploads: make(map[string]*upload),
+		}
+	}
+}
+
+// EnableVersioning enables versioning on a bucket: overwritten and deleted
+// objects are kept as noncurrent versions, which can be retrieved with
+// their VersionId, and deleting an object adds a delete marker.
+func (c *Client) EnableVersioning(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.buckets[name]; ok {
+		b.versioning = true
+	}
+}
+
+// Keys returns the sorted keys of the current objects of a bucket.
+func (c *Client) Keys(name string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.buckets[name]
+	if !ok {
+		return nil
+	}
+	return b.keys("")
+}
+
+// GetObject retrieves an object, or the byte range given by Range.
+func (c *Client) GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	const op = "GetObject"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(params.Bucket)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	o, err := b.version(aws.ToString(params.Key), params.VersionId)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	if err := checkRead(o, params.IfMatch, params.IfNoneMatch, params.IfModifiedSince, params.IfUnmodifiedSince); err != nil {
+		return nil, opError(op, err)
+	}
+
+	data, contentRange := o.data, (*string)(nil)
+	if params.Range != nil {
+		start, end, err := parseRange(aws.ToString(params.Range), int64(len(o.data)))
+		if err != nil {
+			return nil, opError(op, err)
+		}
+		data = o.data[start : end+1]
+		contentRange = aws.String(fmt.Sprintf("bytes %d-%d/%d", start, end, len(o.data)))
+	}
+
+	return &s3.GetObjectOutput{
+		Body:               io.NopCloser(bytes.NewReader(bytes.Clone(data))),
+		ContentLength:      aws.Int64(int64(len(data))),
+		ContentRange:       contentRange,
+		ETag:               aws.String(o.etag),
+		LastModified:       aws.Time(o.modified),
+		Metadata:           cloneMap(o.meta),
+		VersionId:          b.versionID(o),
+		StorageClass:       o.storageClass,
+		CacheControl:       o.header.cacheControl,
+		ContentDisposition: o.header.contentDisposition,
+		ContentEncoding:    o.header.contentEncoding,
+		ContentLanguage:    o.header.contentLanguage,
+		ContentType:        o.header.contentType,
+	}, nil
+}
+
+// HeadObject retrieves the metadata of an object. Like S3, it fails with
+// *types.NotFound rather than *types.NoSuchKey.
+func (c *Client) HeadObject(ctx context.Context, params *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	const op = "HeadObject"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(params.Bucket)
+	if err != nil {
+		return nil, opError(op, &types.NotFound{Message: aws.String("Not Found")})
+	}
+	o, err := b.version(aws.ToString(params.Key), params.VersionId)
+	if err != nil {
+		// a response to HEAD has no body, hence no error code
+		return nil, opError(op, &types.NotFound{Message: aws.String("Not Found")})
+	}
+	if err := checkRead(o, params.IfMatch, params.IfNoneMatch, params.IfModifiedSince, params.IfUnmodifiedSince); err != nil {
+		return nil, opError(op, err)
+	}
+
+	return &s3.HeadObjectOutput{
+		ContentLength:      aws.Int64(int64(len(o.data))),
+		ETag:               aws.String(o.etag),
+		LastModified:       aws.Time(o.modified),
+		Metadata:           cloneMap(o.meta),
+		VersionId:          b.versionID(o),
+		StorageClass:       o.storageClass,
+		CacheControl:       o.header.cacheControl,
+		ContentDisposition: o.header.contentDisposition,
+		ContentEncoding:    o.header.contentEncoding,
+		ContentLanguage:    o.header.contentLanguage,
+		ContentType:        o.header.contentType,
+	}, nil
+}
+
+// PutObject stores an object. With IfNoneMatch "*", it fails if the object
+// exists; with IfMatch, it fails unless the object exists with that ETag.
+func (c *Client) PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	const op = "PutObject"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+
+	var data []byte
+	if params.Body != nil {
+		var err error
+		if data, err = io.ReadAll(params.Body); err != nil {
+			return nil, opError(op, err)
+		}
+	}
+	if params.ContentLength != nil && *params.ContentLength != int64(len(data)) {
+		return nil, opError(op, apiError("IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header."))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(params.Bucket)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	key := aws.ToString(params.Key)
+	if err := checkWrite(b.current(key), params.IfMatch, params.IfNoneMatch); err != nil {
+		return nil, opError(op, err)
+	}
+
+	o := c.put(b, key, data, etag(data), params.Metadata, params.StorageClass, header{
+		cacheControl:       params.CacheControl,
+		contentDisposition: params.ContentDisposition,
+		contentEncoding:    params.ContentEncoding,
+		contentLanguage:    params.ContentLanguage,
+		contentType:        params.ContentType,
+	})
+	return &s3.PutObjectOutput{
+		ETag:      aws.String(o.etag),
+		VersionId: b.versionID(o),
+	}, nil
+}
+
+// CopyObject copies an object on the server side. The copy keeps the
+// metadata and headers of the source, unless MetadataDirective is REPLACE.
+func (c *Client) CopyObject(ctx context.Context, params *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	const op = "CopyObject"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src, err := c.copySource(params.CopySource, params.CopySourceIfMatch, params.CopySourceIfNoneMatch)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	b, err := c.bucket(params.Bucket)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	key := aws.ToString(params.Key)
+
+	meta, h := src.meta, src.header
+	if params.MetadataDirective == types.MetadataDirectiveReplace {
+		meta = params.Metadata
+		h = header{
+			cacheControl:       params.CacheControl,
+			contentDisposition: params.ContentDisposition,
+			contentEncoding:    params.ContentEncoding,
+			contentLanguage:    params.ContentLanguage,
+			contentType:        params.ContentType,
+		}
+	} else if src == b.current(key) && params.StorageClass == "" {
+		return nil, opError(op, apiError("InvalidRequest", "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes."))
+	}
+
+	o := c.put(b, key, bytes.Clone(src.data), src.etag, meta, params.StorageClass, h)
+	return &s3.CopyObjectOutput{
+		CopyObjectResult: &types.CopyObjectResult{
+			ETag:         aws.String(o.etag),
+			LastModified: aws.Time(o.modified),
+		},
+		VersionId: b.versionID(o),
+	}, nil
+}
+
+// DeleteObject deletes an object, or one of its versions. Deleting a
+// missing object succeeds, unless IfMatch is set.
+func (c *Client) DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	const op = "DeleteObject"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(params.Bucket)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	key := aws.ToString(params.Key)
+	if params.IfMatch != nil {
+		o := b.current(key)
+		if o == nil {
+			return nil, opError(op, noSuchKey())
+		}
+		if !matchETag(aws.ToString(params.IfMatch), o.etag) {
+			return nil, opError(op, preconditionFailed())
+		}
+	}
+
+	marker, versionID := c.delete(b, key, params.VersionId)
+	return &s3.DeleteObjectOutput{
+		DeleteMarker: aws.Bool(marker),
+		VersionId:    versionID,
+	}, nil
+}
+
+// DeleteObjects deletes up to 1000 objects.
+func (c *Client) DeleteObjects(ctx context.Context, params *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	const op = "DeleteObjects"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(params.Bucket)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	if params.Delete == nil || len(params.Delete.Objects) == 0 || len(params.Delete.Objects) > maxKeys {
+		return nil, opError(op, apiError("MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema."))
+	}
+
+	output := &s3.DeleteObjectsOutput{}
+	for _, id := range params.Delete.Objects {
+		marker, versionID := c.delete(b, aws.ToString(id.Key), id.VersionId)
+		if !aws.ToBool(params.Delete.Quiet) {
+			output.Deleted = append(output.Deleted, types.DeletedObject{
+				Key:          id.Key,
+				DeleteMarker: aws.Bool(marker),
+				VersionId:    versionID,
+			})
+		}
+	}
+	return output, nil
+}
+
+// ListObjectsV2 lists the current objects of a bucket in key order. With a
+// Delimiter, the keys sharing a prefix up to the next delimiter are rolled
+// up into CommonPrefixes. Pages hold up to MaxKeys keys and prefixes.
+func (c *Client) ListObjectsV2(ctx context.Context, params *s3.ListObjectsV2Input, optFns ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	const op = "ListObjectsV2"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(params.Bucket)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	prefix := aws.ToString(params.Prefix)
+	delimiter := aws.ToString(params.Delimiter)
+	limit := int32(maxKeys)
+	if params.MaxKeys != nil && *params.MaxKeys < limit {
+		limit = max(*params.MaxKeys, 0)
+	}
+
+	after := aws.ToString(params.StartAfter)
+	if params.ContinuationToken != nil {
+		token, err := base64.StdEncoding.DecodeString(*params.ContinuationToken)
+		if err != nil {
+			return nil, opError(op, apiError("InvalidArgument", "The continuation token provided is incorrect"))
+		}
+		after = string(token)
+	}
+
+	output := &s3.ListObjectsV2Output{
+		Name:              params.Bucket,
+		Prefix:            params.Prefix,
+		Delimiter:         params.Delimiter,
+		MaxKeys:           aws.Int32(limit),
+		StartAfter:        params.StartAfter,
+		ContinuationToken: params.ContinuationToken,
+	}
+	var count int32
+	last := ""
+	for _, key := range b.keys(prefix) {
+		if key <= after || (strings.HasSuffix(after, delimiter) && delimiter != "" && strings.HasPrefix(key, after)) {
+			// before the page, or rolled up into the last prefix of the
+			// previous page
+			continue
+		}
+
+		entry, rolledUp := key, false
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				entry, rolledUp = key[:len(prefix)+i+len(delimiter)], true
+			}
+		}
+		if entry == last {
+			continue
+		}
+		if count == limit {
+			if limit > 0 {
+				output.IsTruncated = aws.Bool(true)
+				output.NextContinuationToken = aws.String(base64.StdEncoding.EncodeToString([]byte(last)))
+			}
+			break
+		}
+
+		if rolledUp {
+			output.CommonPrefixes = append(output.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(entry)})
+		} else {
+			o := b.current(key)
+			output.Contents = append(output.Contents, types.Object{
+				Key:          aws.String(key),
+				Size:         aws.Int64(int64(len(o.data))),
+				ETag:         aws.String(o.etag),
+				LastModified: aws.Time(o.modified),
+				StorageClass: types.ObjectStorageClass(storageClass(o.storageClass)),
+			})
+		}
+		last = entry
+		count++
+	}
+	if output.IsTruncated == nil {
+		output.IsTruncated = aws.Bool(false)
+	}
+	output.KeyCount = aws.Int32(count)
+	return output, nil
+}
+
+// bucket returns the bucket with the given name.
+func (c *Client) bucket(name *string) (*bucket, error) {
+	b, ok := c.buckets[aws.ToString(name)]
+	if !ok {
+		return nil, &types.NoSuchBucket{Message: aws.String("The specified bucket does not exist")}
+	}
+	return b, nil
+}
+
+// copySource returns the object named by the CopySource of a copy request,
+// if it matches the conditions.
+func (c *Client) copySource(source, ifMatch, ifNoneMatch *string) (*object, error) {
+	s := strings.TrimPrefix(aws.ToString(source), "/")
+	s, query, _ := strings.Cut(s, "?")
+	name, escapedKey, ok := strings.Cut(s, "/")
+	if !ok {
+		return nil, apiError("InvalidArgument", "Invalid copy source object key")
+	}
+	key, err := url.PathUnescape(escapedKey)
+	if err != nil {
+		return nil, apiError("InvalidArgument", "Invalid copy source encoding")
+	}
+	var versionID *string
+	if values, err := url.ParseQuery(query); err == nil && values.Has("versionId") {
+		versionID = aws.String(values.Get("versionId"))
+	}
+
+	b, err := c.bucket(&name)
+	if err != nil {
+		return nil, err
+	}
+	src, err := b.version(key, versionID)
+	if err != nil {
+		return nil, err
+	}
+	if ifMatch != nil && !matchETag(*ifMatch, src.etag) {
+		return nil, preconditionFailed()
+	}
+	if ifNoneMatch != nil && matchETag(*ifNoneMatch, src.etag) {
+		return nil, preconditionFailed()
+	}
+	return src, nil
+}
+
+// put stores a new current version of the object key.
+func (c *Client) put(b *bucket, key string, data []byte, etag string, meta map[string]string, class types.StorageClass, h header) *object {
+	o := &object{
+		key:          key,
+		data:         data,
+		etag:         etag,
+		modified:     time.Now().UTC().Truncate(time.Millisecond),
+		meta:         lowerKeys(meta),
+		header:       h,
+		storageClass: class,
+	}
+	if b.versioning {
+		o.versionID = c.nextID()
+		b.objects[key] = append(b.objects[key], o)
+	} else {
+		b.objects[key] = []*object{o}
+	}
+	return o
+}
+
+// delete deletes the object key, or one of its versions, and reports
+// whether a delete marker was created or deleted, and its version.
+func (c *Client) delete(b *bucket, key string, versionID *string) (bool, *string) {
+	versions := b.objects[key]
+	if versionID != nil {
+		for i, o := range versions {
+			if o.versionID == *versionID {
+				b.objects[key] = append(versions[:i:i], versions[i+1:]...)
+				if len(b.objects[key]) == 0 {
+					delete(b.objects, key)
+				}
+				return o.deleteMarker, versionID
+			}
+		}
+		return false, versionID
+	}
+
+	if !b.versioning {
+		delete(b.objects, key)
+		return false, nil
+	}
+	marker := &object{
+		key:          key,
+		versionID:    c.nextID(),
+		modified:     time.Now().UTC().Truncate(time.Millisecond),
+		deleteMarker: true,
+	}
+	b.objects[key] = append(versions, marker)
+	return true, aws.String(marker.versionID)
+}
+
+func (c *Client) nextID() string {
+	c.seq++
+	return fmt.Sprintf("%016x", c.seq)
+}
+
+// current returns the current version of the object key, or nil if it
+// doesn't exist or is deleted.
+func (b *bucket) current(key string) *object {
+	versions := b.objects[key]
+	if len(versions) == 0 || versions[len(versions)-1].deleteMarker {
+		return nil
+	}
+	return versions[len(versions)-1]
+}
+
+// version returns the given version of the object key, or its current
+// version if versionID is nil.
+func (b *bucket) version(key string, versionID *string) (*object, error) {
+	if versionID == nil {
+		if o := b.current(key); o != nil {
+			return o, nil
+		}
+		return nil, noSuchKey()
+	}
+	for _, o := range b.objects[key] {
+		if o.versionID == *versionID {
+			if o.deleteMarker {
+				return nil, apiError("MethodNotAllowed", "The specified method is not allowed against this resource.")
+			}
+			return o, nil
+		}
+	}
+	return nil, apiError("NoSuchVersion", "The specified version does not exist.")
+}
+
+// versionID returns the VersionId of o in responses, which is only set on
+// versioned buckets.
+func (b *bucket) versionID(o *object) *string {
+	if !b.versioning {
+		return nil
+	}
+	return aws.String(o.versionID)
+}
+
+// keys returns the sorted keys of the current objects starting with
+// prefix.
+func (b *bucket) keys(prefix string) []string {
+	var keys []string
+	for key := range b.objects {
+		if strings.HasPrefix(key, prefix) && b.current(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkRead evaluates the conditions of a GET or HEAD request on o.
+func checkRead(o *object, ifMatch, ifNoneMatch *string, ifModifiedSince, ifUnmodifiedSince *time.Time) error {
+	if ifMatch != nil && !matchETag(*ifMatch, o.etag) {
+		return preconditionFailed()
+	}
+	if ifMatch == nil && ifUnmodifiedSince != nil && o.modified.After(*ifUnmodifiedSince) {
+		return preconditionFailed()
+	}
+	if ifNoneMatch != nil && matchETag(*ifNoneMatch, o.etag) {
+		return apiError("NotModified", "Not Modified")
+	}
+	if ifNoneMatch == nil && ifModifiedSince != nil && !o.modified.After(*ifModifiedSince) {
+		return apiError("NotModified", "Not Modified")
+	}
+	return nil
+}
+
+// checkWrite evaluates the conditions of a write request on the current
+// object o, which is nil if it doesn't exist.
+func checkWrite(o *object, ifMatch, ifNoneMatch *string) error {
+	if ifNoneMatch != nil {
+		if aws.ToString(ifNoneMatch) != "*" {
+			return apiError("NotImplemented", "A header you provided implies functionality that is not implemented")
+		}
+		if o != nil {
+			return preconditionFailed()
+		}
+	}
+	if ifMatch != nil {
+		if o == nil {
+			return noSuchKey()
+		}
+		if !matchETag(*ifMatch, o.etag) {
+			return preconditionFailed()
+		}
+	}
+	return nil
+}
+
+// matchETag reports whether the value of an If-Match or If-None-Match
+// header, a list of ETags or "*", matches etag.
+func matchETag(cond, etag string) bool {
+	for _, c := range strings.Split(cond, ",") {
+		c = strings.TrimSpace(c)
+		if c == "*" || strings.Trim(c, `"`) == strings.Trim(etag, `"`) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRange parses the value of a Range header for an object of the given
+// size, and returns the first and last bytes of the range.
+func parseRange(s string, size int64) (start, end int64, err error) {
+	invalid := apiError("InvalidRange", "The requested range is not satisfiable")
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, invalid
+	}
+	first, last, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, invalid
+	}
+
+	if first == "" {
+		// the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, invalid
+		}
+		return max(size-n, 0), size - 1, nil
+	}
+
+	start, err = strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, invalid
+	}
+	end = size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, invalid
+		}
+		end = min(end, size-1)
+	}
+	return start, end, nil
+}
+
+// etag returns the ETag of an object uploaded with a single request.
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// checksumCRC32 returns the base64-encoded CRC32 checksum of data.
+func checksumCRC32(data []byte) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], crc32.ChecksumIEEE(data))
+	return base64.StdEncoding.EncodeToString(b[:])
+}
+
+// storageClass returns the storage class of an object in responses.
+func storageClass(class types.StorageClass) types.StorageClass {
+	if class == "" {
+		return types.StorageClassStandard
+	}
+	return class
+}
+
+// lowerKeys returns a copy of user metadata with lowercase keys, like the
+// metadata returned by the SDK, which reads them from HTTP headers.
+func lowerKeys(meta map[string]string) map[string]string {
+	if meta == nil {
+		return nil
+	}
+	lower := make(map[string]string, len(meta))
+	for k, v := range meta {
+		lower[strings.ToLower(k)] = v
+	}
+	return lower
+}
+
+func cloneMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	clone := make(map[string]string, len(m))
+	for k, v := range m {
+		clone[k] = v
+	}
+	return clone
+}
+
+// apiError returns an error response of S3 without a modeled type.
+func apiError(code, message string) error {
+	return &smithy.GenericAPIError{Code: code, Message: message}
+}
+
+func noSuchKey() error {
+	return &types.NoSuchKey{Message: aws.String("The specified key does not exist.")}
+}
+
+func preconditionFailed() error {
+	return apiError("PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+}
+
+// opError wraps the error of an operation like the SDK does.
+func opError(op string, err error) error {
+	return &smithy.OperationError{ServiceID: "S3", OperationName: op, Err: err}
+}
+
+// canceled returns the error of an operation whose context is done.
+func canceled(op string, err error) error {
+	return opError(op, &smithy.CanceledError{Err: err})
+}
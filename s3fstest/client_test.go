@@ -0,0 +1,326 @@
+package s3fstest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func put(t *testing.T, c *Client, key, data string) *s3.PutObjectOutput {
+	t.Helper()
+	out, err := c.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String(key),
+		Body:   strings.NewReader(data),
+	})
+	require.NoError(t, err)
+	return out
+}
+
+func get(t *testing.T, c *Client, in *s3.GetObjectInput) (string, error) {
+	t.Helper()
+	if in.Bucket == nil {
+		in.Bucket = aws.String("bucket")
+	}
+	out, err := c.GetObject(context.Background(), in)
+	if err != nil {
+		return "", err
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	require.NoError(t, err)
+	return string(data), nil
+}
+
+func errorCode(err error) string {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
+
+func TestClient_PutGetHead(t *testing.T) {
+	c := NewClient("bucket")
+	ctx := context.Background()
+
+	out, err := c.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String("bucket"),
+		Key:         aws.String("dir/file"),
+		Body:        strings.NewReader("hello"),
+		Metadata:    map[string]string{"Mode": "644"},
+		ContentType: aws.String("text/plain"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `"5d41402abc4b2a76b9719d911017c592"`, aws.ToString(out.ETag))
+
+	head, err := c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("dir/file")})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), aws.ToInt64(head.ContentLength))
+	assert.Equal(t, out.ETag, head.ETag)
+	assert.Equal(t, map[string]string{"mode": "644"}, head.Metadata)
+	assert.Equal(t, "text/plain", aws.ToString(head.ContentType))
+
+	data, err := get(t, c, &s3.GetObjectInput{Key: aws.String("dir/file")})
+	require.NoError(t, err)
+	assert.Equal(t, "hello", data)
+
+	_, err = get(t, c, &s3.GetObjectInput{Key: aws.String("missing")})
+	var noSuchKey *types.NoSuchKey
+	assert.ErrorAs(t, err, &noSuchKey)
+
+	_, err = c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("missing")})
+	var notFound *types.NotFound
+	assert.ErrorAs(t, err, &notFound)
+
+	_, err = get(t, c, &s3.GetObjectInput{Key: aws.String("file"), Bucket: aws.String("other")})
+	assert.Equal(t, "NoSuchBucket", errorCode(err))
+
+	assert.Equal(t, []string{"dir/file"}, c.Keys("bucket"))
+}
+
+func TestClient_Range(t *testing.T) {
+	c := NewClient("bucket")
+	put(t, c, "file", "0123456789")
+
+	tests := []struct {
+		rng  string
+		want string
+	}{
+		{"bytes=0-3", "0123"},
+		{"bytes=5-", "56789"},
+		{"bytes=-3", "789"},
+		{"bytes=8-100", "89"},
+	}
+	for _, tt := range tests {
+		data, err := get(t, c, &s3.GetObjectInput{Key: aws.String("file"), Range: aws.String(tt.rng)})
+		require.NoError(t, err, tt.rng)
+		assert.Equal(t, tt.want, data, tt.rng)
+	}
+
+	_, err := get(t, c, &s3.GetObjectInput{Key: aws.String("file"), Range: aws.String("bytes=10-")})
+	assert.Equal(t, "InvalidRange", errorCode(err))
+}
+
+func TestClient_Conditions(t *testing.T) {
+	c := NewClient("bucket")
+	ctx := context.Background()
+	etag := aws.ToString(put(t, c, "file", "v1").ETag)
+
+	// create only if absent
+	_, err := c.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String("bucket"),
+		Key:         aws.String("file"),
+		Body:        strings.NewReader("v2"),
+		IfNoneMatch: aws.String("*"),
+	})
+	assert.Equal(t, "PreconditionFailed", errorCode(err))
+
+	// replace only if unchanged
+	_, err = c.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:  aws.String("bucket"),
+		Key:     aws.String("file"),
+		Body:    strings.NewReader("v2"),
+		IfMatch: aws.String(`"0"`),
+	})
+	assert.Equal(t, "PreconditionFailed", errorCode(err))
+	_, err = c.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:  aws.String("bucket"),
+		Key:     aws.String("file"),
+		Body:    strings.NewReader("v2"),
+		IfMatch: aws.String(etag),
+	})
+	require.NoError(t, err)
+
+	_, err = get(t, c, &s3.GetObjectInput{Key: aws.String("file"), IfMatch: aws.String(etag)})
+	assert.Equal(t, "PreconditionFailed", errorCode(err))
+
+	_, err = c.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket:  aws.String("bucket"),
+		Key:     aws.String("file"),
+		IfMatch: aws.String(etag),
+	})
+	assert.Equal(t, "PreconditionFailed", errorCode(err))
+	assert.Equal(t, []string{"file"}, c.Keys("bucket"))
+}
+
+func TestClient_ListObjectsV2(t *testing.T) {
+	c := NewClient("bucket")
+	for _, key := range []string{"a", "dir/", "dir/b", "dir/c", "dir/sub/d", "e"} {
+		put(t, c, key, key)
+	}
+
+	out, err := c.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{
+		Bucket:    aws.String("bucket"),
+		Prefix:    aws.String("dir/"),
+		Delimiter: aws.String("/"),
+	})
+	require.NoError(t, err)
+	var keys, prefixes []string
+	for _, o := range out.Contents {
+		keys = append(keys, aws.ToString(o.Key))
+	}
+	for _, p := range out.CommonPrefixes {
+		prefixes = append(prefixes, aws.ToString(p.Prefix))
+	}
+	assert.Equal(t, []string{"dir/", "dir/b", "dir/c"}, keys)
+	assert.Equal(t, []string{"dir/sub/"}, prefixes)
+
+	// pages are joined back by the paginator of the SDK
+	p := s3.NewListObjectsV2Paginator(c, &s3.ListObjectsV2Input{
+		Bucket:  aws.String("bucket"),
+		MaxKeys: aws.Int32(2),
+	})
+	keys = nil
+	pages := 0
+	for p.HasMorePages() {
+		page, err := p.NextPage(context.Background())
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Contents), 2)
+		for _, o := range page.Contents {
+			keys = append(keys, aws.ToString(o.Key))
+		}
+		pages++
+	}
+	assert.Equal(t, []string{"a", "dir/", "dir/b", "dir/c", "dir/sub/d", "e"}, keys)
+	assert.Equal(t, 3, pages)
+}
+
+func TestClient_Multipart(t *testing.T) {
+	c := NewClient("bucket")
+	ctx := context.Background()
+
+	create, err := c.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("big"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, c.Uploads("bucket"))
+
+	parts := [][]byte{bytes.Repeat([]byte("a"), MinPartSize), []byte("tail")}
+	var completed []types.CompletedPart
+	for i, data := range parts {
+		num := aws.Int32(int32(i + 1))
+		out, err := c.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String("bucket"),
+			Key:        aws.String("big"),
+			UploadId:   create.UploadId,
+			PartNumber: num,
+			Body:       bytes.NewReader(data),
+		})
+		require.NoError(t, err)
+		completed = append(completed, types.CompletedPart{PartNumber: num, ETag: out.ETag})
+	}
+
+	// parts must be listed in order
+	_, err = c.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bucket"),
+		Key:             aws.String("big"),
+		UploadId:        create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: []types.CompletedPart{completed[1], completed[0]}},
+	})
+	assert.Equal(t, "InvalidPartOrder", errorCode(err))
+
+	out, err := c.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bucket"),
+		Key:             aws.String("big"),
+		UploadId:        create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(aws.ToString(out.ETag), `-2"`))
+	assert.Equal(t, 0, c.Uploads("bucket"))
+
+	data, err := get(t, c, &s3.GetObjectInput{Key: aws.String("big"), Range: aws.String(fmt.Sprintf("bytes=%d-", MinPartSize-1))})
+	require.NoError(t, err)
+	assert.Equal(t, "atail", data)
+}
+
+func TestClient_MultipartTooSmall(t *testing.T) {
+	c := NewClient("bucket")
+	ctx := context.Background()
+
+	create, err := c.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("file"),
+	})
+	require.NoError(t, err)
+	var completed []types.CompletedPart
+	for i := int32(1); i <= 2; i++ {
+		out, err := c.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:     aws.String("bucket"),
+			Key:        aws.String("file"),
+			UploadId:   create.UploadId,
+			PartNumber: aws.Int32(i),
+			Body:       strings.NewReader("small"),
+		})
+		require.NoError(t, err)
+		completed = append(completed, types.CompletedPart{PartNumber: aws.Int32(i), ETag: out.ETag})
+	}
+	_, err = c.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bucket"),
+		Key:             aws.String("file"),
+		UploadId:        create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	assert.Equal(t, "EntityTooSmall", errorCode(err))
+
+	_, err = c.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String("bucket"),
+		Key:      aws.String("file"),
+		UploadId: create.UploadId,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.Uploads("bucket"))
+	assert.Empty(t, c.Keys("bucket"))
+}
+
+func TestClient_Versioning(t *testing.T) {
+	c := NewClient("bucket")
+	c.EnableVersioning("bucket")
+	ctx := context.Background()
+
+	v1 := put(t, c, "file", "v1")
+	put(t, c, "file", "v2")
+	require.NotNil(t, v1.VersionId)
+
+	data, err := get(t, c, &s3.GetObjectInput{Key: aws.String("file")})
+	require.NoError(t, err)
+	assert.Equal(t, "v2", data)
+	data, err = get(t, c, &s3.GetObjectInput{Key: aws.String("file"), VersionId: v1.VersionId})
+	require.NoError(t, err)
+	assert.Equal(t, "v1", data)
+
+	// deleting adds a delete marker, older versions are kept
+	del, err := c.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: aws.String("bucket"), Key: aws.String("file")})
+	require.NoError(t, err)
+	assert.True(t, aws.ToBool(del.DeleteMarker))
+	assert.Empty(t, c.Keys("bucket"))
+
+	_, err = get(t, c, &s3.GetObjectInput{Key: aws.String("file")})
+	var noSuchKey *types.NoSuchKey
+	assert.ErrorAs(t, err, &noSuchKey)
+	data, err = get(t, c, &s3.GetObjectInput{Key: aws.String("file"), VersionId: v1.VersionId})
+	require.NoError(t, err)
+	assert.Equal(t, "v1", data)
+}
+
+func TestClient_Canceled(t *testing.T) {
+	c := NewClient("bucket")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := c.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String("bucket"), Key: aws.String("file")})
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,278 @@
+package s3fstest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// MinPartSize is the size S3 requires of every part of a multipart upload
+// but the last one.
+const MinPartSize = 5 << 20
+
+type upload struct {
+	key               string
+	meta              map[string]string
+	header            header
+	storageClass      types.StorageClass
+	checksumAlgorithm types.ChecksumAlgorithm
+	parts             map[int32]*part
+}
+
+type part struct {
+	data     []byte
+	etag     string
+	checksum string // CRC32, if requested
+}
+
+// CreateMultipartUpload starts a multipart upload.
+func (c *Client) CreateMultipartUpload(ctx context.Context, params *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	const op = "CreateMultipartUpload"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(params.Bucket)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	id := c.nextID()
+	b.uploads[id] = &upload{
+		key:  aws.ToString(params.Key),
+		meta: params.Metadata,
+		header: header{
+			cacheControl:       params.CacheControl,
+			contentDisposition: params.ContentDisposition,
+			contentEncoding:    params.ContentEncoding,
+			contentLanguage:    params.ContentLanguage,
+			contentType:        params.ContentType,
+		},
+		storageClass:      params.StorageClass,
+		checksumAlgorithm: params.ChecksumAlgorithm,
+		parts:             make(map[int32]*part),
+	}
+	return &s3.CreateMultipartUploadOutput{
+		Bucket:            params.Bucket,
+		Key:               params.Key,
+		UploadId:          aws.String(id),
+		ChecksumAlgorithm: params.ChecksumAlgorithm,
+	}, nil
+}
+
+// UploadPart uploads a part of a multipart upload, replacing any previous
+// part with the same number.
+func (c *Client) UploadPart(ctx context.Context, params *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	const op = "UploadPart"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+
+	var data []byte
+	if params.Body != nil {
+		var err error
+		if data, err = io.ReadAll(params.Body); err != nil {
+			return nil, opError(op, err)
+		}
+	}
+	if params.ContentLength != nil && *params.ContentLength != int64(len(data)) {
+		return nil, opError(op, apiError("IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header."))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	u, err := c.upload(params.Bucket, params.Key, params.UploadId)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	p, err := u.addPart(params.PartNumber, data)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	return &s3.UploadPartOutput{
+		ETag:          aws.String(p.etag),
+		ChecksumCRC32: checksumOutput(p),
+	}, nil
+}
+
+// UploadPartCopy uploads a part of a multipart upload by copying the byte
+// range CopySourceRange, or all, of an existing object.
+func (c *Client) UploadPartCopy(ctx context.Context, params *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	const op = "UploadPartCopy"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	src, err := c.copySource(params.CopySource, params.CopySourceIfMatch, params.CopySourceIfNoneMatch)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	u, err := c.upload(params.Bucket, params.Key, params.UploadId)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+
+	data := src.data
+	if params.CopySourceRange != nil {
+		start, end, err := parseRange(*params.CopySourceRange, int64(len(data)))
+		if err != nil {
+			return nil, opError(op, err)
+		}
+		data = data[start : end+1]
+	}
+	p, err := u.addPart(params.PartNumber, bytes.Clone(data))
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	return &s3.UploadPartCopyOutput{
+		CopyPartResult: &types.CopyPartResult{
+			ETag:          aws.String(p.etag),
+			LastModified:  aws.Time(time.Now().UTC().Truncate(time.Millisecond)),
+			ChecksumCRC32: checksumOutput(p),
+		},
+	}, nil
+}
+
+// CompleteMultipartUpload assembles the given parts, in ascending order of
+// their numbers, into an object. Every part but the last one must be at
+// least MinPartSize bytes. IfNoneMatch and IfMatch apply to the object
+// being replaced, like for PutObject.
+func (c *Client) CompleteMultipartUpload(ctx context.Context, params *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	const op = "CompleteMultipartUpload"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(params.Bucket)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	u, err := c.upload(params.Bucket, params.Key, params.UploadId)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	if params.MultipartUpload == nil || len(params.MultipartUpload.Parts) == 0 {
+		return nil, opError(op, apiError("MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema."))
+	}
+
+	completed := params.MultipartUpload.Parts
+	for i := 1; i < len(completed); i++ {
+		if aws.ToInt32(completed[i].PartNumber) <= aws.ToInt32(completed[i-1].PartNumber) {
+			return nil, opError(op, apiError("InvalidPartOrder", "The list of parts was not in ascending order."))
+		}
+	}
+
+	var data, sums []byte
+	for i, cp := range completed {
+		p, ok := u.parts[aws.ToInt32(cp.PartNumber)]
+		if !ok || !matchETag(aws.ToString(cp.ETag), p.etag) {
+			return nil, opError(op, apiError("InvalidPart", "One or more of the specified parts could not be found."))
+		}
+		if cp.ChecksumCRC32 != nil && *cp.ChecksumCRC32 != p.checksum {
+			return nil, opError(op, apiError("InvalidPart", "The checksum of a part does not match."))
+		}
+		if i < len(completed)-1 && len(p.data) < MinPartSize {
+			return nil, opError(op, apiError("EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size."))
+		}
+
+		data = append(data, p.data...)
+		sum, _ := hex.DecodeString(p.etag[1 : len(p.etag)-1])
+		sums = append(sums, sum...)
+	}
+
+	if err := checkWrite(b.current(u.key), params.IfMatch, params.IfNoneMatch); err != nil {
+		return nil, opError(op, err)
+	}
+
+	sum := md5.Sum(sums)
+	etag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(completed))
+	o := c.put(b, u.key, data, etag, u.meta, u.storageClass, u.header)
+	delete(b.uploads, aws.ToString(params.UploadId))
+
+	return &s3.CompleteMultipartUploadOutput{
+		Bucket:    params.Bucket,
+		Key:       params.Key,
+		ETag:      aws.String(o.etag),
+		VersionId: b.versionID(o),
+	}, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts.
+func (c *Client) AbortMultipartUpload(ctx context.Context, params *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	const op = "AbortMultipartUpload"
+	if err := ctx.Err(); err != nil {
+		return nil, canceled(op, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, err := c.bucket(params.Bucket)
+	if err != nil {
+		return nil, opError(op, err)
+	}
+	if _, err := c.upload(params.Bucket, params.Key, params.UploadId); err != nil {
+		return nil, opError(op, err)
+	}
+	delete(b.uploads, aws.ToString(params.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+// Uploads returns the number of multipart uploads of a bucket that are
+// neither completed nor aborted.
+func (c *Client) Uploads(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.buckets[name]
+	if !ok {
+		return 0
+	}
+	return len(b.uploads)
+}
+
+// upload returns the multipart upload id of the object key.
+func (c *Client) upload(bucketName, key, id *string) (*upload, error) {
+	b, err := c.bucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	u, ok := b.uploads[aws.ToString(id)]
+	if !ok || u.key != aws.ToString(key) {
+		return nil, &types.NoSuchUpload{Message: aws.String("The specified upload does not exist.")}
+	}
+	return u, nil
+}
+
+// addPart stores the part num of u.
+func (u *upload) addPart(num *int32, data []byte) (*part, error) {
+	n := aws.ToInt32(num)
+	if n < 1 || n > 10000 {
+		return nil, apiError("InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive")
+	}
+	p := &part{data: data, etag: etag(data)}
+	if u.checksumAlgorithm == types.ChecksumAlgorithmCrc32 {
+		p.checksum = checksumCRC32(data)
+	}
+	u.parts[n] = p
+	return p, nil
+}
+
+func checksumOutput(p *part) *string {
+	if p.checksum == "" {
+		return nil
+	}
+	return aws.String(p.checksum)
+}
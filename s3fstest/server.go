@@ -0,0 +1,907 @@
+package s3fstest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// Credentials of the clients of a Handler. Requests signed with other
+// credentials are rejected.
+const (
+	AccessKeyID     = "S3FSTEST"
+	SecretAccessKey = "s3fstest-secret"
+)
+
+// ServerBucket is the bucket of the servers started by NewServer.
+const ServerBucket = "bucket"
+
+// NewServer starts an S3-compatible HTTPS server backed by a Client with
+// the empty bucket ServerBucket, and returns an SDK client configured to
+// use it, and the name of the bucket. The server is closed when the test
+// ends.
+//
+// Unlike a Client used directly, requests go through the whole request
+// path of the SDK: serialization, signing, checksums, retries and
+// response parsing.
+func NewServer(t testing.TB) (*s3.Client, string) {
+	t.Helper()
+	srv := httptest.NewTLSServer(NewHandler(NewClient(ServerBucket)))
+	t.Cleanup(srv.Close)
+	return newServerClient(srv), ServerBucket
+}
+
+// newServerClient returns an SDK client of srv, with path-style addressing
+// and the credentials accepted by Handler.
+func newServerClient(srv *httptest.Server) *s3.Client {
+	return s3.New(s3.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(srv.URL),
+		UsePathStyle: true,
+		HTTPClient:   srv.Client(),
+		// the defaults of config.LoadDefaultConfig
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenSupported,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenSupported,
+		Credentials: aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+			return aws.Credentials{AccessKeyID: AccessKeyID, SecretAccessKey: SecretAccessKey, Source: "s3fstest"}, nil
+		}),
+	})
+}
+
+// Handler serves the REST API of S3, with path-style addressing, from a
+// Client. It implements the operations of the Client, verifies the
+// signatures of the requests and decodes aws-chunked request bodies.
+type Handler struct {
+	backend *Client
+	seq     atomic.Int64 // source of request IDs
+}
+
+// NewHandler returns a Handler serving the buckets of backend.
+func NewHandler(backend *Client) *Handler {
+	return &Handler{backend: backend}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Amz-Request-Id", fmt.Sprintf("%016X", h.seq.Add(1)))
+	if err := authenticate(r); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	bucket, key, err := splitPath(r.URL)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	q := r.URL.Query()
+	copySource := r.Header.Get("X-Amz-Copy-Source") != ""
+
+	var serve func(http.ResponseWriter, *http.Request, *string, *string) error
+	if key == "" {
+		switch {
+		case bucket == "":
+		case r.Method == http.MethodGet && q.Get("list-type") == "2":
+			serve = h.listObjectsV2
+		case r.Method == http.MethodPost && q.Has("delete"):
+			serve = h.deleteObjects
+		}
+	} else {
+		switch {
+		case r.Method == http.MethodGet:
+			serve = h.getObject
+		case r.Method == http.MethodHead:
+			serve = h.headObject
+		case r.Method == http.MethodPut && q.Has("uploadId") && copySource:
+			serve = h.uploadPartCopy
+		case r.Method == http.MethodPut && q.Has("uploadId"):
+			serve = h.uploadPart
+		case r.Method == http.MethodPut && copySource:
+			serve = h.copyObject
+		case r.Method == http.MethodPut:
+			serve = h.putObject
+		case r.Method == http.MethodDelete && q.Has("uploadId"):
+			serve = h.abortMultipartUpload
+		case r.Method == http.MethodDelete:
+			serve = h.deleteObject
+		case r.Method == http.MethodPost && q.Has("uploads"):
+			serve = h.createMultipartUpload
+		case r.Method == http.MethodPost && q.Has("uploadId"):
+			serve = h.completeMultipartUpload
+		}
+	}
+	if serve == nil {
+		writeError(w, r, apiError("NotImplemented", "A header or query you provided implies functionality that is not implemented."))
+		return
+	}
+	if err := serve(w, r, aws.String(bucket), aws.String(key)); err != nil {
+		writeError(w, r, err)
+	}
+}
+
+func (h *Handler) getObject(w http.ResponseWriter, r *http.Request, bucket, key *string) error {
+	q := r.URL.Query()
+	out, err := h.backend.GetObject(r.Context(), &s3.GetObjectInput{
+		Bucket:            bucket,
+		Key:               key,
+		VersionId:         query(q, "versionId"),
+		Range:             requestHeader(r, "Range"),
+		IfMatch:           requestHeader(r, "If-Match"),
+		IfNoneMatch:       requestHeader(r, "If-None-Match"),
+		IfModifiedSince:   timeHeader(r, "If-Modified-Since"),
+		IfUnmodifiedSince: timeHeader(r, "If-Unmodified-Since"),
+	})
+	if err != nil {
+		return err
+	}
+	defer out.Body.Close()
+
+	setObjectHeaders(w, objectHeaders{
+		contentLength: out.ContentLength,
+		etag:          out.ETag,
+		lastModified:  out.LastModified,
+		metadata:      out.Metadata,
+		versionID:     out.VersionId,
+		storageClass:  out.StorageClass,
+		header: header{
+			cacheControl:       out.CacheControl,
+			contentDisposition: out.ContentDisposition,
+			contentEncoding:    out.ContentEncoding,
+			contentLanguage:    out.ContentLanguage,
+			contentType:        out.ContentType,
+		},
+	})
+	status := http.StatusOK
+	if out.ContentRange != nil {
+		w.Header().Set("Content-Range", *out.ContentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	_, err = io.Copy(w, out.Body)
+	return err
+}
+
+func (h *Handler) headObject(w http.ResponseWriter, r *http.Request, bucket, key *string) error {
+	out, err := h.backend.HeadObject(r.Context(), &s3.HeadObjectInput{
+		Bucket:            bucket,
+		Key:               key,
+		VersionId:         query(r.URL.Query(), "versionId"),
+		IfMatch:           requestHeader(r, "If-Match"),
+		IfNoneMatch:       requestHeader(r, "If-None-Match"),
+		IfModifiedSince:   timeHeader(r, "If-Modified-Since"),
+		IfUnmodifiedSince: timeHeader(r, "If-Unmodified-Since"),
+	})
+	if err != nil {
+		return err
+	}
+	setObjectHeaders(w, objectHeaders{
+		contentLength: out.ContentLength,
+		etag:          out.ETag,
+		lastModified:  out.LastModified,
+		metadata:      out.Metadata,
+		versionID:     out.VersionId,
+		storageClass:  out.StorageClass,
+		header: header{
+			cacheControl:       out.CacheControl,
+			contentDisposition: out.ContentDisposition,
+			contentEncoding:    out.ContentEncoding,
+			contentLanguage:    out.ContentLanguage,
+			contentType:        out.ContentType,
+		},
+	})
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (h *Handler) putObject(w http.ResponseWriter, r *http.Request, bucket, key *string) error {
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	out, err := h.backend.PutObject(r.Context(), &s3.PutObjectInput{
+		Bucket:             bucket,
+		Key:                key,
+		Body:               bytes.NewReader(data),
+		Metadata:           metadata(r),
+		StorageClass:       types.StorageClass(r.Header.Get("X-Amz-Storage-Class")),
+		IfMatch:            requestHeader(r, "If-Match"),
+		IfNoneMatch:        requestHeader(r, "If-None-Match"),
+		CacheControl:       requestHeader(r, "Cache-Control"),
+		ContentDisposition: requestHeader(r, "Content-Disposition"),
+		ContentEncoding:    contentEncoding(r),
+		ContentLanguage:    requestHeader(r, "Content-Language"),
+		ContentType:        requestHeader(r, "Content-Type"),
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", aws.ToString(out.ETag))
+	setHeader(w, "X-Amz-Version-Id", out.VersionId)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (h *Handler) copyObject(w http.ResponseWriter, r *http.Request, bucket, key *string) error {
+	out, err := h.backend.CopyObject(r.Context(), &s3.CopyObjectInput{
+		Bucket:                bucket,
+		Key:                   key,
+		CopySource:            requestHeader(r, "X-Amz-Copy-Source"),
+		CopySourceIfMatch:     requestHeader(r, "X-Amz-Copy-Source-If-Match"),
+		CopySourceIfNoneMatch: requestHeader(r, "X-Amz-Copy-Source-If-None-Match"),
+		MetadataDirective:     types.MetadataDirective(r.Header.Get("X-Amz-Metadata-Directive")),
+		Metadata:              metadata(r),
+		StorageClass:          types.StorageClass(r.Header.Get("X-Amz-Storage-Class")),
+		CacheControl:          requestHeader(r, "Cache-Control"),
+		ContentDisposition:    requestHeader(r, "Content-Disposition"),
+		ContentEncoding:       requestHeader(r, "Content-Encoding"),
+		ContentLanguage:       requestHeader(r, "Content-Language"),
+		ContentType:           requestHeader(r, "Content-Type"),
+	})
+	if err != nil {
+		return err
+	}
+	setHeader(w, "X-Amz-Version-Id", out.VersionId)
+	return writeXML(w, http.StatusOK, copyResult{
+		XMLName:      xml.Name{Local: "CopyObjectResult"},
+		ETag:         aws.ToString(out.CopyObjectResult.ETag),
+		LastModified: formatTime(out.CopyObjectResult.LastModified),
+	})
+}
+
+func (h *Handler) deleteObject(w http.ResponseWriter, r *http.Request, bucket, key *string) error {
+	out, err := h.backend.DeleteObject(r.Context(), &s3.DeleteObjectInput{
+		Bucket:    bucket,
+		Key:       key,
+		VersionId: query(r.URL.Query(), "versionId"),
+		IfMatch:   requestHeader(r, "If-Match"),
+	})
+	if err != nil {
+		return err
+	}
+	if aws.ToBool(out.DeleteMarker) {
+		w.Header().Set("X-Amz-Delete-Marker", "true")
+	}
+	setHeader(w, "X-Amz-Version-Id", out.VersionId)
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) deleteObjects(w http.ResponseWriter, r *http.Request, bucket, _ *string) error {
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var req deleteRequest
+	if err := xml.Unmarshal(data, &req); err != nil {
+		return malformedXML()
+	}
+	in := &s3.DeleteObjectsInput{
+		Bucket: bucket,
+		Delete: &types.Delete{Quiet: aws.Bool(req.Quiet)},
+	}
+	for _, o := range req.Objects {
+		in.Delete.Objects = append(in.Delete.Objects, types.ObjectIdentifier{Key: aws.String(o.Key), VersionId: o.VersionID})
+	}
+	out, err := h.backend.DeleteObjects(r.Context(), in)
+	if err != nil {
+		return err
+	}
+
+	result := deleteResult{}
+	for _, d := range out.Deleted {
+		result.Deleted = append(result.Deleted, deletedEntry{
+			Key:          aws.ToString(d.Key),
+			VersionID:    aws.ToString(d.VersionId),
+			DeleteMarker: aws.ToBool(d.DeleteMarker),
+		})
+	}
+	for _, e := range out.Errors {
+		result.Errors = append(result.Errors, errorEntry{
+			Key:     aws.ToString(e.Key),
+			Code:    aws.ToString(e.Code),
+			Message: aws.ToString(e.Message),
+		})
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func (h *Handler) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket, _ *string) error {
+	q := r.URL.Query()
+	in := &s3.ListObjectsV2Input{
+		Bucket:            bucket,
+		Prefix:            query(q, "prefix"),
+		Delimiter:         query(q, "delimiter"),
+		StartAfter:        query(q, "start-after"),
+		ContinuationToken: query(q, "continuation-token"),
+	}
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil || n < 0 {
+			return apiError("InvalidArgument", "Provided max-keys not an integer or within integer range")
+		}
+		in.MaxKeys = aws.Int32(int32(n))
+	}
+	out, err := h.backend.ListObjectsV2(r.Context(), in)
+	if err != nil {
+		return err
+	}
+
+	result := listResult{
+		Name:                  aws.ToString(out.Name),
+		Prefix:                aws.ToString(out.Prefix),
+		Delimiter:             aws.ToString(out.Delimiter),
+		MaxKeys:               aws.ToInt32(out.MaxKeys),
+		KeyCount:              aws.ToInt32(out.KeyCount),
+		IsTruncated:           aws.ToBool(out.IsTruncated),
+		ContinuationToken:     aws.ToString(out.ContinuationToken),
+		NextContinuationToken: aws.ToString(out.NextContinuationToken),
+		StartAfter:            aws.ToString(out.StartAfter),
+	}
+	for _, o := range out.Contents {
+		result.Contents = append(result.Contents, listEntry{
+			Key:          aws.ToString(o.Key),
+			LastModified: formatTime(o.LastModified),
+			ETag:         aws.ToString(o.ETag),
+			Size:         aws.ToInt64(o.Size),
+			StorageClass: string(o.StorageClass),
+		})
+	}
+	for _, p := range out.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: aws.ToString(p.Prefix)})
+	}
+	return writeXML(w, http.StatusOK, result)
+}
+
+func (h *Handler) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key *string) error {
+	out, err := h.backend.CreateMultipartUpload(r.Context(), &s3.CreateMultipartUploadInput{
+		Bucket:             bucket,
+		Key:                key,
+		Metadata:           metadata(r),
+		StorageClass:       types.StorageClass(r.Header.Get("X-Amz-Storage-Class")),
+		ChecksumAlgorithm:  types.ChecksumAlgorithm(r.Header.Get("X-Amz-Checksum-Algorithm")),
+		CacheControl:       requestHeader(r, "Cache-Control"),
+		ContentDisposition: requestHeader(r, "Content-Disposition"),
+		ContentEncoding:    requestHeader(r, "Content-Encoding"),
+		ContentLanguage:    requestHeader(r, "Content-Language"),
+		ContentType:        requestHeader(r, "Content-Type"),
+	})
+	if err != nil {
+		return err
+	}
+	if out.ChecksumAlgorithm != "" {
+		w.Header().Set("X-Amz-Checksum-Algorithm", string(out.ChecksumAlgorithm))
+	}
+	return writeXML(w, http.StatusOK, initiateResult{
+		Bucket:   aws.ToString(out.Bucket),
+		Key:      aws.ToString(out.Key),
+		UploadID: aws.ToString(out.UploadId),
+	})
+}
+
+func (h *Handler) uploadPart(w http.ResponseWriter, r *http.Request, bucket, key *string) error {
+	num, err := partNumber(r)
+	if err != nil {
+		return err
+	}
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	out, err := h.backend.UploadPart(r.Context(), &s3.UploadPartInput{
+		Bucket:     bucket,
+		Key:        key,
+		UploadId:   query(r.URL.Query(), "uploadId"),
+		PartNumber: num,
+		Body:       bytes.NewReader(data),
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("ETag", aws.ToString(out.ETag))
+	setHeader(w, "X-Amz-Checksum-Crc32", out.ChecksumCRC32)
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (h *Handler) uploadPartCopy(w http.ResponseWriter, r *http.Request, bucket, key *string) error {
+	num, err := partNumber(r)
+	if err != nil {
+		return err
+	}
+	out, err := h.backend.UploadPartCopy(r.Context(), &s3.UploadPartCopyInput{
+		Bucket:                bucket,
+		Key:                   key,
+		UploadId:              query(r.URL.Query(), "uploadId"),
+		PartNumber:            num,
+		CopySource:            requestHeader(r, "X-Amz-Copy-Source"),
+		CopySourceRange:       requestHeader(r, "X-Amz-Copy-Source-Range"),
+		CopySourceIfMatch:     requestHeader(r, "X-Amz-Copy-Source-If-Match"),
+		CopySourceIfNoneMatch: requestHeader(r, "X-Amz-Copy-Source-If-None-Match"),
+	})
+	if err != nil {
+		return err
+	}
+	return writeXML(w, http.StatusOK, copyResult{
+		XMLName:       xml.Name{Local: "CopyPartResult"},
+		ETag:          aws.ToString(out.CopyPartResult.ETag),
+		LastModified:  formatTime(out.CopyPartResult.LastModified),
+		ChecksumCRC32: aws.ToString(out.CopyPartResult.ChecksumCRC32),
+	})
+}
+
+func (h *Handler) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key *string) error {
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	var req completeRequest
+	if err := xml.Unmarshal(data, &req); err != nil {
+		return malformedXML()
+	}
+	parts := &types.CompletedMultipartUpload{}
+	for _, p := range req.Parts {
+		parts.Parts = append(parts.Parts, types.CompletedPart{
+			PartNumber:    aws.Int32(p.PartNumber),
+			ETag:          aws.String(p.ETag),
+			ChecksumCRC32: p.ChecksumCRC32,
+		})
+	}
+	out, err := h.backend.CompleteMultipartUpload(r.Context(), &s3.CompleteMultipartUploadInput{
+		Bucket:          bucket,
+		Key:             key,
+		UploadId:        query(r.URL.Query(), "uploadId"),
+		MultipartUpload: parts,
+		IfMatch:         requestHeader(r, "If-Match"),
+		IfNoneMatch:     requestHeader(r, "If-None-Match"),
+	})
+	if err != nil {
+		return err
+	}
+	setHeader(w, "X-Amz-Version-Id", out.VersionId)
+	return writeXML(w, http.StatusOK, completeResult{
+		Bucket: aws.ToString(out.Bucket),
+		Key:    aws.ToString(out.Key),
+		ETag:   aws.ToString(out.ETag),
+	})
+}
+
+func (h *Handler) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key *string) error {
+	_, err := h.backend.AbortMultipartUpload(r.Context(), &s3.AbortMultipartUploadInput{
+		Bucket:   bucket,
+		Key:      key,
+		UploadId: query(r.URL.Query(), "uploadId"),
+	})
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// authenticate verifies the AWS Signature Version 4 of r, by signing it
+// again with the headers it claims to sign.
+func authenticate(r *http.Request) error {
+	const algorithm = "AWS4-HMAC-SHA256 "
+	auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), algorithm)
+	if !ok {
+		return apiError("AccessDenied", "Access Denied")
+	}
+	var credential, signedHeaders string
+	for _, field := range strings.Split(auth, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		}
+	}
+	// access key ID/date/region/service/aws4_request
+	scope := strings.Split(credential, "/")
+	if len(scope) != 5 {
+		return apiError("AuthorizationHeaderMalformed", "The authorization header is malformed.")
+	}
+	if scope[0] != AccessKeyID {
+		return apiError("InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records.")
+	}
+	signingTime, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return apiError("AccessDenied", "AWS authentication requires a valid Date or x-amz-date header")
+	}
+
+	req := &http.Request{
+		Method: r.Method,
+		URL: &url.URL{
+			Scheme:   "https",
+			Host:     r.Host,
+			Path:     r.URL.Path,
+			RawPath:  r.URL.RawPath,
+			RawQuery: r.URL.RawQuery,
+		},
+		Host:   r.Host,
+		Header: make(http.Header),
+	}
+	for _, name := range strings.Split(signedHeaders, ";") {
+		switch name {
+		case "host":
+		case "content-length":
+			req.ContentLength = r.ContentLength
+		default:
+			req.Header[http.CanonicalHeaderKey(name)] = r.Header.Values(name)
+		}
+	}
+	signer := v4.NewSigner(func(o *v4.SignerOptions) {
+		o.DisableURIPathEscaping = true
+	})
+	creds := aws.Credentials{AccessKeyID: AccessKeyID, SecretAccessKey: SecretAccessKey}
+	err = signer.SignHTTP(r.Context(), creds, req, r.Header.Get("X-Amz-Content-Sha256"), scope[3], scope[2], signingTime)
+	if err != nil || req.Header.Get("Authorization") != r.Header.Get("Authorization") {
+		return apiError("SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.")
+	}
+	return nil
+}
+
+// splitPath returns the bucket and the key of a path-style URL.
+func splitPath(u *url.URL) (bucket, key string, err error) {
+	bucket, escapedKey, _ := strings.Cut(strings.TrimPrefix(u.EscapedPath(), "/"), "/")
+	if key, err = url.PathUnescape(escapedKey); err != nil {
+		return "", "", apiError("InvalidURI", "Couldn't parse the specified URI.")
+	}
+	return bucket, key, nil
+}
+
+// readBody reads the body of r, decoding it if it is aws-chunked, and
+// verifies its SHA-256 and CRC32 checksums if they are sent.
+func readBody(r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	trailer := http.Header{}
+	if isChunked(r) {
+		if data, trailer, err = decodeChunked(data); err != nil {
+			return nil, apiError("IncompleteBody", err.Error())
+		}
+		if n := r.Header.Get("X-Amz-Decoded-Content-Length"); n != "" && n != strconv.Itoa(len(data)) {
+			return nil, apiError("IncompleteBody", "The decoded content length does not match.")
+		}
+	}
+
+	if hash := r.Header.Get("X-Amz-Content-Sha256"); len(hash) == sha256.Size*2 {
+		sum := sha256.Sum256(data)
+		if hash != hex.EncodeToString(sum[:]) {
+			return nil, apiError("XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.")
+		}
+	}
+	sum := r.Header.Get("X-Amz-Checksum-Crc32")
+	if sum == "" {
+		sum = trailer.Get("X-Amz-Checksum-Crc32")
+	}
+	if sum != "" && sum != checksumCRC32(data) {
+		return nil, apiError("BadDigest", "The CRC32 you specified did not match the calculated checksum.")
+	}
+	return data, nil
+}
+
+// isChunked reports whether the body of r has the aws-chunked encoding.
+func isChunked(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") ||
+		strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-")
+}
+
+// decodeChunked decodes an aws-chunked body: chunks, each preceded by its
+// hexadecimal size and optionally a signature, ending with an empty chunk
+// followed by trailing headers.
+func decodeChunked(body []byte) ([]byte, http.Header, error) {
+	var data []byte
+	for {
+		line, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		if !ok {
+			return nil, nil, errors.New("missing chunk size")
+		}
+		size, _, _ := strings.Cut(string(line), ";")
+		n, err := strconv.ParseUint(size, 16, 31)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid chunk size %q", size)
+		}
+		if n == 0 {
+			body = rest
+			break
+		}
+		if uint64(len(rest)) < n+2 || !bytes.Equal(rest[n:n+2], []byte("\r\n")) {
+			return nil, nil, errors.New("truncated chunk")
+		}
+		data = append(data, rest[:n]...)
+		body = rest[n+2:]
+	}
+
+	trailer := http.Header{}
+	for len(body) > 0 {
+		line, rest, _ := bytes.Cut(body, []byte("\r\n"))
+		if name, value, ok := strings.Cut(string(line), ":"); ok {
+			trailer.Add(name, strings.TrimSpace(value))
+		}
+		body = rest
+	}
+	return data, trailer, nil
+}
+
+// contentEncoding returns the Content-Encoding of r without aws-chunked,
+// which only applies to the request.
+func contentEncoding(r *http.Request) *string {
+	var encodings []string
+	for _, e := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if e = strings.TrimSpace(e); e != "" && e != "aws-chunked" {
+			encodings = append(encodings, e)
+		}
+	}
+	if len(encodings) == 0 {
+		return nil
+	}
+	return aws.String(strings.Join(encodings, ","))
+}
+
+func partNumber(r *http.Request) (*int32, error) {
+	n, err := strconv.ParseInt(r.URL.Query().Get("partNumber"), 10, 32)
+	if err != nil {
+		return nil, apiError("InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive")
+	}
+	return aws.Int32(int32(n)), nil
+}
+
+// metadata returns the user metadata of r, sent as x-amz-meta- headers.
+func metadata(r *http.Request) map[string]string {
+	const prefix = "x-amz-meta-"
+	meta := make(map[string]string)
+	for name, values := range r.Header {
+		if k, ok := strings.CutPrefix(strings.ToLower(name), prefix); ok && len(values) > 0 {
+			meta[k] = values[0]
+		}
+	}
+	return meta
+}
+
+func requestHeader(r *http.Request, name string) *string {
+	if values := r.Header.Values(name); len(values) > 0 {
+		return aws.String(values[0])
+	}
+	return nil
+}
+
+func timeHeader(r *http.Request, name string) *time.Time {
+	t, err := http.ParseTime(r.Header.Get(name))
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func query(q url.Values, name string) *string {
+	if !q.Has(name) {
+		return nil
+	}
+	return aws.String(q.Get(name))
+}
+
+// objectHeaders are the headers of a response to GET or HEAD.
+type objectHeaders struct {
+	contentLength *int64
+	etag          *string
+	lastModified  *time.Time
+	metadata      map[string]string
+	versionID     *string
+	storageClass  types.StorageClass
+	header
+}
+
+func setObjectHeaders(w http.ResponseWriter, h objectHeaders) {
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Length", strconv.FormatInt(aws.ToInt64(h.contentLength), 10))
+	w.Header().Set("ETag", aws.ToString(h.etag))
+	w.Header().Set("Last-Modified", aws.ToTime(h.lastModified).Format(http.TimeFormat))
+	for k, v := range h.metadata {
+		w.Header().Set("X-Amz-Meta-"+k, v)
+	}
+	setHeader(w, "X-Amz-Version-Id", h.versionID)
+	if h.storageClass != "" && h.storageClass != types.StorageClassStandard {
+		w.Header().Set("X-Amz-Storage-Class", string(h.storageClass))
+	}
+	setHeader(w, "Cache-Control", h.cacheControl)
+	setHeader(w, "Content-Disposition", h.contentDisposition)
+	setHeader(w, "Content-Encoding", h.contentEncoding)
+	setHeader(w, "Content-Language", h.contentLanguage)
+	if h.contentType != nil {
+		w.Header().Set("Content-Type", *h.contentType)
+	} else {
+		// keep net/http from sniffing a content type
+		w.Header()["Content-Type"] = nil
+	}
+}
+
+func setHeader(w http.ResponseWriter, name string, value *string) {
+	if value != nil {
+		w.Header().Set(name, *value)
+	}
+}
+
+// writeError writes the error response of err. Errors of the Client have
+// the code of an S3 error, other errors are internal errors.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code, message := "InternalError", err.Error()
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code, message = apiErr.ErrorCode(), apiErr.ErrorMessage()
+	}
+	status := errorStatus(code)
+	if r.Method == http.MethodHead || status == http.StatusNotModified {
+		// no body
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, errorResponse{
+		Code:      code,
+		Message:   message,
+		Resource:  r.URL.Path,
+		RequestID: w.Header().Get("X-Amz-Request-Id"),
+	})
+}
+
+// errorStatus returns the HTTP status of the responses with the error
+// code.
+func errorStatus(code string) int {
+	switch code {
+	case "NoSuchBucket", "NoSuchKey", "NoSuchUpload", "NoSuchVersion", "NotFound":
+		return http.StatusNotFound
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch":
+		return http.StatusForbidden
+	case "PreconditionFailed":
+		return http.StatusPreconditionFailed
+	case "NotModified":
+		return http.StatusNotModified
+	case "InvalidRange":
+		return http.StatusRequestedRangeNotSatisfiable
+	case "MethodNotAllowed":
+		return http.StatusMethodNotAllowed
+	case "ConditionalRequestConflict":
+		return http.StatusConflict
+	case "NotImplemented":
+		return http.StatusNotImplemented
+	case "SlowDown":
+		return http.StatusServiceUnavailable
+	case "InternalError":
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) error {
+	body, err := xml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(len(xml.Header)+len(body)))
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	_, err = w.Write(body)
+	return err
+}
+
+func malformedXML() error {
+	return apiError("MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.")
+}
+
+// formatTime formats t like the timestamps of XML responses.
+func formatTime(t *time.Time) string {
+	return aws.ToTime(t).UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// XML documents of requests and responses.
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string
+	Message   string
+	Resource  string
+	RequestID string `xml:"RequestId"`
+}
+
+type listResult struct {
+	XMLName               xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string
+	Prefix                string
+	Delimiter             string `xml:",omitempty"`
+	MaxKeys               int32
+	KeyCount              int32
+	IsTruncated           bool
+	ContinuationToken     string `xml:",omitempty"`
+	NextContinuationToken string `xml:",omitempty"`
+	StartAfter            string `xml:",omitempty"`
+	Contents              []listEntry
+	CommonPrefixes        []commonPrefix
+}
+
+type listEntry struct {
+	Key          string
+	LastModified string
+	ETag         string
+	Size         int64
+	StorageClass string
+}
+
+type commonPrefix struct {
+	Prefix string
+}
+
+type copyResult struct {
+	XMLName       xml.Name
+	ETag          string
+	LastModified  string
+	ChecksumCRC32 string `xml:",omitempty"`
+}
+
+type deleteRequest struct {
+	Objects []struct {
+		Key       string
+		VersionID *string `xml:"VersionId"`
+	} `xml:"Object"`
+	Quiet bool
+}
+
+type deleteResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []deletedEntry
+	Errors  []errorEntry `xml:"Error"`
+}
+
+type deletedEntry struct {
+	Key          string
+	VersionID    string `xml:"VersionId,omitempty"`
+	DeleteMarker bool   `xml:",omitempty"`
+}
+
+type errorEntry struct {
+	Key     string
+	Code    string
+	Message string
+}
+
+type initiateResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string
+	Key      string
+	UploadID string `xml:"UploadId"`
+}
+
+type completeRequest struct {
+	Parts []struct {
+		PartNumber    int32
+		ETag          string
+		ChecksumCRC32 *string
+	} `xml:"Part"`
+}
+
+type completeResult struct {
+	XMLName xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Bucket  string
+	Key     string
+	ETag    string
+}
@@ -0,0 +1,218 @@
+package s3fstest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Objects(t *testing.T) {
+	client, bucket := NewServer(t)
+	ctx := context.Background()
+
+	out, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String("dir/a file+"),
+		Body:        strings.NewReader("0123456789"),
+		Metadata:    map[string]string{"Mode": "644"},
+		ContentType: aws.String("text/plain"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `"781e5e245d69b566979b86e28d23f2c7"`, aws.ToString(out.ETag))
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String("dir/a file+")})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), aws.ToInt64(head.ContentLength))
+	assert.Equal(t, out.ETag, head.ETag)
+	assert.Equal(t, map[string]string{"mode": "644"}, head.Metadata)
+	assert.Equal(t, "text/plain", aws.ToString(head.ContentType))
+
+	get, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("dir/a file+"),
+		Range:  aws.String("bytes=2-4"),
+	})
+	require.NoError(t, err)
+	data, err := io.ReadAll(get.Body)
+	require.NoError(t, err)
+	get.Body.Close()
+	assert.Equal(t, "234", string(data))
+	assert.Equal(t, "bytes 2-4/10", aws.ToString(get.ContentRange))
+
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("missing")})
+	var noSuchKey *types.NoSuchKey
+	assert.ErrorAs(t, err, &noSuchKey)
+
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String("missing")})
+	var notFound *types.NotFound
+	assert.ErrorAs(t, err, &notFound)
+
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String("dir/a file+"),
+		Body:        strings.NewReader("other"),
+		IfNoneMatch: aws.String("*"),
+	})
+	assert.Equal(t, "PreconditionFailed", errorCode(err))
+
+	_, err = client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:     aws.String(bucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(bucket + "/dir/a%20file%2B"),
+	})
+	require.NoError(t, err)
+
+	del, err := client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &types.Delete{Objects: []types.ObjectIdentifier{{Key: aws.String("copy")}, {Key: aws.String("dir/a file+")}}},
+	})
+	require.NoError(t, err)
+	assert.Len(t, del.Deleted, 2)
+
+	list, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{Bucket: aws.String(bucket)})
+	require.NoError(t, err)
+	assert.Empty(t, list.Contents)
+}
+
+func TestServer_Paginator(t *testing.T) {
+	client, bucket := NewServer(t)
+	ctx := context.Background()
+
+	var want []string
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("dir/%02d", i)
+		_, err := client.PutObject(ctx, &s3.PutObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Body: strings.NewReader(key)})
+		require.NoError(t, err)
+		want = append(want, key)
+	}
+
+	p := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String("dir/"),
+		MaxKeys: aws.Int32(10),
+	})
+	var keys []string
+	pages := 0
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		require.NoError(t, err)
+		for _, o := range page.Contents {
+			keys = append(keys, aws.ToString(o.Key))
+			assert.Equal(t, int64(len(aws.ToString(o.Key))), aws.ToInt64(o.Size))
+		}
+		pages++
+	}
+	assert.Equal(t, want, keys)
+	assert.Equal(t, 3, pages)
+}
+
+func TestServer_Multipart(t *testing.T) {
+	client, bucket := NewServer(t)
+	ctx := context.Background()
+
+	create, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:            aws.String(bucket),
+		Key:               aws.String("big"),
+		ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+	})
+	require.NoError(t, err)
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), (MinPartSize+1024)/16)
+	var parts []types.CompletedPart
+	for i, chunk := range [][]byte{data[:MinPartSize], data[MinPartSize:]} {
+		num := aws.Int32(int32(i + 1))
+		out, err := client.UploadPart(ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(bucket),
+			Key:               aws.String("big"),
+			UploadId:          create.UploadId,
+			PartNumber:        num,
+			Body:              bytes.NewReader(chunk),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+		})
+		require.NoError(t, err)
+		require.NotNil(t, out.ChecksumCRC32)
+		parts = append(parts, types.CompletedPart{PartNumber: num, ETag: out.ETag, ChecksumCRC32: out.ChecksumCRC32})
+	}
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucket),
+		Key:             aws.String("big"),
+		UploadId:        create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	require.NoError(t, err)
+
+	get, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("big")})
+	require.NoError(t, err)
+	defer get.Body.Close()
+	got, err := io.ReadAll(get.Body)
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, got))
+}
+
+func TestServer_Signature(t *testing.T) {
+	srv := httptest.NewTLSServer(NewHandler(NewClient("bucket")))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		creds aws.Credentials
+		code  string
+	}{
+		{aws.Credentials{AccessKeyID: AccessKeyID, SecretAccessKey: "wrong"}, "SignatureDoesNotMatch"},
+		{aws.Credentials{AccessKeyID: "OTHER", SecretAccessKey: SecretAccessKey}, "InvalidAccessKeyId"},
+	} {
+		client := s3.New(newServerClient(srv).Options(), func(o *s3.Options) {
+			o.Credentials = aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+				return tt.creds, nil
+			})
+		})
+		_, err := client.ListObjectsV2(context.Background(), &s3.ListObjectsV2Input{Bucket: aws.String("bucket")})
+		assert.Equal(t, tt.code, errorCode(err))
+	}
+}
+
+func TestServer_Retries(t *testing.T) {
+	handler := NewHandler(NewClient("bucket"))
+	var requests atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			writeError(w, r, apiError("SlowDown", "Please reduce your request rate."))
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	client := newServerClient(srv)
+	_, err := client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String("file"),
+		Body:   strings.NewReader("data"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestDecodeChunked(t *testing.T) {
+	body := "4;chunk-signature=abc\r\ndata\r\n3\r\n123\r\n0\r\nx-amz-checksum-crc32:sum==\r\n\r\n"
+	data, trailer, err := decodeChunked([]byte(body))
+	require.NoError(t, err)
+	assert.Equal(t, "data123", string(data))
+	assert.Equal(t, "sum==", trailer.Get("X-Amz-Checksum-Crc32"))
+
+	for _, body := range []string{"", "4\r\nda", "x\r\n", "4\r\ndata0\r\n\r\n"} {
+		_, _, err := decodeChunked([]byte(body))
+		assert.Error(t, err, body)
+	}
+}
@@ -0,0 +1,58 @@
+package s3fs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/test"
+	"github.com/gurza/go-billy-s3fs/s3fstest"
+	check "gopkg.in/check.v1"
+)
+
+// TestFilesystemSuite runs the test suites of go-billy, which memfs and
+// osfs pass, against an in-memory bucket. The tests of behaviors s3fs
+// doesn't share are skipped below, with the reason.
+func TestFilesystemSuite(t *testing.T) { check.TestingT(t) }
+
+type FilesystemSuite struct {
+	test.FilesystemSuite
+}
+
+var _ = check.Suite(&FilesystemSuite{})
+
+func (s *FilesystemSuite) SetUpTest(c *check.C) {
+	fsys, err := New(s3fstest.NewClient("bucket"), "bucket")
+	c.Assert(err, check.IsNil)
+	s.FilesystemSuite = test.NewFilesystemSuite(fsys)
+}
+
+func (s *FilesystemSuite) TestCreateDepthAbsolute(c *check.C) {
+	c.Skip("File.Name returns the name as given, like *os.File, not cleaned")
+}
+
+func (s *FilesystemSuite) TestSymlinkWithChrootCrossBounders(c *check.C) {
+	c.Skip("links are resolved within the chroot, like securejoin, not in the parent filesystem")
+}
+
+// The suites compare errors with ==, but s3fs wraps billy.ErrCrossedBoundary
+// in *os.PathError and *os.LinkError, like errors of the os package.
+
+func (s *FilesystemSuite) TestOpenOutOffBoundary(c *check.C) {
+	_, err := s.FS.Open("../foo")
+	c.Assert(errors.Is(err, billy.ErrCrossedBoundary), check.Equals, true)
+}
+
+func (s *FilesystemSuite) TestStatOutOffBoundary(c *check.C) {
+	_, err := s.FS.Stat("../foo")
+	c.Assert(errors.Is(err, billy.ErrCrossedBoundary), check.Equals, true)
+}
+
+func (s *FilesystemSuite) TestRenameOutOffBoundary(c *check.C) {
+	c.Assert(errors.Is(s.FS.Rename("../foo", "foo"), billy.ErrCrossedBoundary), check.Equals, true)
+	c.Assert(errors.Is(s.FS.Rename("foo", "../foo"), billy.ErrCrossedBoundary), check.Equals, true)
+}
+
+func (s *FilesystemSuite) TestRemoveOutOffBoundary(c *check.C) {
+	c.Assert(errors.Is(s.FS.Remove("../foo"), billy.ErrCrossedBoundary), check.Equals, true)
+}
@@ -2,9 +2,17 @@ package s3fs
 
 import (
 	"os"
+	"strings"
 	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
+// metaSymlinkTarget is the user metadata key holding the target of
+// a symbolic link.
+const metaSymlinkTarget = "symlink-target"
+
 // fileStat is the implementation of FileInfo returned by Stat and Lstat.
 type fileStat struct {
 	name    string
@@ -14,20 +22,88 @@ type fileStat struct {
 	// sys     syscall.Stat_t
 }
 
-func newFileInfo(name string, size int64, modTime time.Time) os.FileInfo {
+// newFileInfo returns the FileInfo of a file. The mode and modification
+// time are read from the POSIX attributes in the user metadata meta, if
+// any, otherwise the mode defaults to 0644 and the modification time to
+// modTime, the time the object was last written.
+func newFileInfo(name string, size int64, modTime time.Time, meta map[string]string) os.FileInfo {
 	return &fileStat{
 		name:    name,
 		size:    size,
-		modTime: modTime,
-		mode:    0644,
+		modTime: timeFromMeta(meta, metaMtime, modTime),
+		mode:    modeFromMeta(meta, 0644),
 	}
 }
 
-func newDirInfo(name string) os.FileInfo {
+// newDirInfo returns the FileInfo of a directory, reading its mode and
+// modification time from the user metadata meta of its marker object,
+// if any. modTime is the time the marker was written, or zero without
+// marker.
+func newDirInfo(name string, modTime time.Time, meta map[string]string) os.FileInfo {
 	return &fileStat{
-		name: name,
-		mode: os.ModeDir | 0755,
+		name:    name,
+		modTime: timeFromMeta(meta, metaMtime, modTime),
+		mode:    os.ModeDir | modeFromMeta(meta, 0755),
+	}
+}
+
+func newSymlinkInfo(name, target string, modTime time.Time) os.FileInfo {
+	return &fileStat{
+		name:    name,
+		size:    int64(len(target)),
+		modTime: modTime,
+		mode:    os.ModeSymlink | 0777,
+	}
+}
+
+// fileInfo returns the FileInfo of an object from its metadata.
+func fileInfo(name string, output *s3.HeadObjectOutput) os.FileInfo {
+	if target, ok := symlinkTarget(output.Metadata); ok {
+		return newSymlinkInfo(name, target, aws.ToTime(output.LastModified))
+	}
+	return newFileInfo(name, aws.ToInt64(output.ContentLength), aws.ToTime(output.LastModified), output.Metadata)
+}
+
+// symlinkTarget returns the target of a symbolic link stored in the user
+// metadata meta. S3 returns metadata keys in lower case, but other
+// backends may not, so the key is matched case-insensitively.
+func symlinkTarget(meta map[string]string) (string, bool) {
+	return metaValue(meta, metaSymlinkTarget)
+}
+
+// modeFromMeta returns the permission bits stored in the user metadata meta,
+// or def if there are none.
+func modeFromMeta(meta map[string]string, def os.FileMode) os.FileMode {
+	if v, ok := metaValue(meta, metaMode); ok {
+		if m, ok := parseMode(v); ok {
+			return m & modeBits
+		}
+	}
+	return def
+}
+
+// timeFromMeta returns the time stored under key in the user metadata meta,
+// or def if there is none.
+func timeFromMeta(meta map[string]string, key string, def time.Time) time.Time {
+	if v, ok := metaValue(meta, key); ok {
+		if t, ok := parseTime(v); ok {
+			return t
+		}
+	}
+	return def
+}
+
+// metaValue looks up key in the user metadata meta case-insensitively.
+func metaValue(meta map[string]string, key string) (string, bool) {
+	if v, ok := meta[key]; ok {
+		return v, true
+	}
+	for k, v := range meta {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
 	}
+	return "", false
 }
 
 func (fs *fileStat) Name() string       { return fs.name }
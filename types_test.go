@@ -4,6 +4,11 @@ import (
 	"os"
 	"reflect"
 	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestFileStat_ImplementsOSFileInfo(t *testing.T) {
@@ -16,3 +21,70 @@ func TestFileStat_ImplementsOSFileInfo(t *testing.T) {
 		t.Errorf("fileStat does not implement os.FileInfo interface")
 	}
 }
+
+func TestFileInfo(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	fi := fileInfo("file.txt", &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(42),
+		LastModified:  aws.Time(modTime),
+	})
+	assert.Equal(t, "file.txt", fi.Name())
+	assert.Equal(t, int64(42), fi.Size())
+	assert.Equal(t, modTime, fi.ModTime())
+	assert.True(t, fi.Mode().IsRegular())
+
+	fi = fileInfo("link", &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(0),
+		Metadata:      map[string]string{"symlink-target": "../target"},
+	})
+	assert.Equal(t, "link", fi.Name())
+	assert.Equal(t, os.ModeSymlink, fi.Mode().Type())
+	assert.Equal(t, int64(len("../target")), fi.Size())
+}
+
+func TestSymlinkTarget(t *testing.T) {
+	target, ok := symlinkTarget(map[string]string{"symlink-target": "a/b"})
+	assert.True(t, ok)
+	assert.Equal(t, "a/b", target)
+
+	// metadata keys are not always lower case
+	target, ok = symlinkTarget(map[string]string{"Symlink-Target": "c"})
+	assert.True(t, ok)
+	assert.Equal(t, "c", target)
+
+	_, ok = symlinkTarget(map[string]string{"other": "x"})
+	assert.False(t, ok)
+	_, ok = symlinkTarget(nil)
+	assert.False(t, ok)
+}
+
+func TestNewFileInfo_Attributes(t *testing.T) {
+	lastModified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// defaults without metadata
+	fi := newFileInfo("file", 1, lastModified, nil)
+	assert.Equal(t, os.FileMode(0644), fi.Mode())
+	assert.Equal(t, lastModified, fi.ModTime())
+
+	fi = newFileInfo("file", 1, lastModified, map[string]string{
+		"mode":  "33261",
+		"mtime": "1700000000.5",
+	})
+	assert.Equal(t, os.FileMode(0755), fi.Mode())
+	assert.True(t, time.Unix(1700000000, 500000000).Equal(fi.ModTime()))
+
+	// the type bits come from the object, not the metadata
+	fi = newFileInfo("file", 1, lastModified, map[string]string{"mode": "16877"})
+	assert.True(t, fi.Mode().IsRegular())
+
+	// invalid values are ignored
+	fi = newFileInfo("file", 1, lastModified, map[string]string{"mode": "rwx", "mtime": "now"})
+	assert.Equal(t, os.FileMode(0644), fi.Mode())
+	assert.Equal(t, lastModified, fi.ModTime())
+
+	di := newDirInfo("dir", lastModified, map[string]string{"mode": "16832"})
+	assert.Equal(t, os.ModeDir|0700, di.Mode())
+	assert.Equal(t, lastModified, di.ModTime())
+	assert.True(t, di.IsDir())
+}
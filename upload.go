@@ -0,0 +1,280 @@
+package s3fs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/go-git/go-billy/v5"
+)
+
+// uploadFile is a write-only billy.File that streams its content to S3.
+//
+// Data is buffered until a full part is available, which is then sent with
+// UploadPart while the caller keeps writing. The part size and the number
+// of parts in flight are bounded (see WithPartSize), so memory stays
+// bounded regardless of the file size. Files smaller than a single part are
+// uploaded with one PutObject on Close. S3 accepts at most 10,000 parts,
+// beyond which Write fails with syscall.EFBIG.
+type uploadFile struct {
+	fs     *S3FS
+	key    string
+	name   string
+	flag   int
+	meta   map[string]string // user metadata of the uploaded object
+	buf    []byte
+	size   int64
+	closed bool
+	lock   fileLock
+
+	uploadID *string
+	partNum  int32
+	sem      chan struct{}
+	wg       sync.WaitGroup
+
+	mu    sync.Mutex // guards parts and err
+	parts []types.CompletedPart
+	err   error
+}
+
+var _ billy.File = (*uploadFile)(nil)
+
+func newUploadFile(fs *S3FS, key, name string, flag int, meta map[string]string) *uploadFile {
+	return &uploadFile{
+		fs:   fs,
+		key:  key,
+		name: name,
+		flag: flag,
+		meta: meta,
+		sem:  make(chan struct{}, fs.opts.uploadConcurrency),
+	}
+}
+
+func (f *uploadFile) Read(b []byte) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *uploadFile) ReadAt(b []byte, off int64) (int, error) {
+	return 0, os.ErrPermission
+}
+
+func (f *uploadFile) Write(b []byte) (int, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if err := f.uploadErr(); err != nil {
+		return 0, f.fs.pathError("write", f.name, err)
+	}
+
+	n := 0
+	for len(b) > 0 {
+		if len(f.buf) == 0 && f.partNum == maxUploadParts {
+			err := fmt.Errorf("file exceeds %d parts of %d bytes: %w", maxUploadParts, f.fs.opts.partSize, syscall.EFBIG)
+			f.setErr(err)
+			return n, f.fs.pathError("write", f.name, err)
+		}
+
+		c := f.grow(len(b))
+		f.buf = append(f.buf, b[:c]...)
+		b = b[c:]
+		n += c
+		f.size += int64(c)
+
+		if int64(len(f.buf)) == f.fs.opts.partSize {
+			if err := f.sendPart(); err != nil {
+				return n, f.fs.pathError("write", f.name, err)
+			}
+		}
+	}
+	return n, nil
+}
+
+// grow makes room in the buffer for up to n more bytes of the current part
+// and returns the number of bytes that fit. The buffer grows as data
+// arrives, up to the part size, so that small files don't hold a whole
+// part in memory.
+func (f *uploadFile) grow(n int) int {
+	partSize := int(f.fs.opts.partSize)
+	n = min(n, partSize-len(f.buf))
+	if len(f.buf)+n > cap(f.buf) {
+		buf := make([]byte, len(f.buf), min(max(2*cap(f.buf), len(f.buf)+n), partSize))
+		copy(buf, f.buf)
+		f.buf = buf
+	}
+	return n
+}
+
+// Seek only reports the current offset, since the data already sent
+// cannot be rewritten.
+func (f *uploadFile) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if (whence == io.SeekStart && offset == f.size) || (whence != io.SeekStart && offset == 0) {
+		return f.size, nil
+	}
+	return 0, billy.ErrNotSupported
+}
+
+func (f *uploadFile) Truncate(size int64) error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	if size == f.size {
+		return nil
+	}
+	return billy.ErrNotSupported
+}
+
+// Close finishes the upload. Small files are sent with a single PutObject,
+// otherwise the remaining data is sent as the last part and the multipart
+// upload is completed. The multipart upload is aborted on any error.
+func (f *uploadFile) Close() error {
+	if f.closed {
+		return os.ErrClosed
+	}
+	f.closed = true
+	defer f.lock.release(f.fs)
+
+	if f.uploadID == nil {
+		err := f.uploadErr()
+		if err == nil {
+			err = f.fs.writeObject(f.key, f.buf, f.meta, isExclusive(f.flag))
+		}
+		if err != nil {
+			return f.fs.pathError("close", f.name, err)
+		}
+		return nil
+	}
+
+	if len(f.buf) > 0 {
+		// a failure is recorded and reported by uploadErr below
+		_ = f.sendPart()
+	}
+	f.wg.Wait()
+
+	err := f.uploadErr()
+	if err == nil {
+		err = f.complete()
+	}
+	if err != nil {
+		f.fs.abortUpload(f.key, f.uploadID)
+		return f.fs.pathError("close", f.name, err)
+	}
+	return nil
+}
+
+func (f *uploadFile) Name() string {
+	return f.name
+}
+
+func (f *uploadFile) Lock() error {
+	return f.lock.lock(f.fs, f.key)
+}
+
+func (f *uploadFile) Unlock() error {
+	return f.lock.unlock(f.fs)
+}
+
+// sendPart starts the multipart upload if needed and sends the buffered
+// data as the next part in the background.
+func (f *uploadFile) sendPart() error {
+	if f.uploadID == nil {
+		out, err := f.fs.client.CreateMultipartUpload(f.fs.ctx, &s3.CreateMultipartUploadInput{
+			Bucket:            aws.String(f.fs.bucket),
+			Key:               aws.String(f.key),
+			Metadata:          f.meta,
+			StorageClass:      f.fs.opts.storageClass,
+			ContentType:       f.fs.contentType(f.key),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+		})
+		if err != nil {
+			err = mapError(err)
+			f.setErr(err)
+			return err
+		}
+		f.uploadID = out.UploadId
+	}
+
+	f.partNum++
+	num, body := f.partNum, f.buf
+	f.buf = nil
+
+	// wait for a free slot, which bounds the number of buffers in memory
+	f.sem <- struct{}{}
+	f.wg.Add(1)
+	go func() {
+		defer func() {
+			<-f.sem
+			f.wg.Done()
+		}()
+
+		out, err := f.fs.client.UploadPart(f.fs.ctx, &s3.UploadPartInput{
+			Bucket:            aws.String(f.fs.bucket),
+			Key:               aws.String(f.key),
+			UploadId:          f.uploadID,
+			PartNumber:        aws.Int32(num),
+			Body:              bytes.NewReader(body),
+			ContentLength:     aws.Int64(int64(len(body))),
+			ChecksumAlgorithm: types.ChecksumAlgorithmCrc32,
+		})
+		if err != nil {
+			f.setErr(mapError(err))
+			return
+		}
+
+		f.mu.Lock()
+		f.parts = append(f.parts, types.CompletedPart{
+			ETag:          out.ETag,
+			PartNumber:    aws.Int32(num),
+			ChecksumCRC32: out.ChecksumCRC32,
+		})
+		f.mu.Unlock()
+	}()
+	return nil
+}
+
+// complete assembles the uploaded parts into the final object.
+func (f *uploadFile) complete() error {
+	sort.Slice(f.parts, func(i, j int) bool {
+		return *f.parts[i].PartNumber < *f.parts[j].PartNumber
+	})
+
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(f.fs.bucket),
+		Key:             aws.String(f.key),
+		UploadId:        f.uploadID,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: f.parts},
+	}
+	excl := isExclusive(f.flag)
+	if excl {
+		input.IfNoneMatch = aws.String("*")
+	}
+	_, err := f.fs.client.CompleteMultipartUpload(f.fs.ctx, input)
+	err = mapError(err)
+	if excl {
+		return existError(err)
+	}
+	return err
+}
+
+func (f *uploadFile) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+func (f *uploadFile) uploadErr() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.err
+}
@@ -2,6 +2,7 @@ package s3fs
 
 import (
 	"errors"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -29,8 +30,9 @@ func isSubPath(basepath, targpath string) bool {
 	if err != nil {
 		return false
 	}
-	if strings.HasPrefix(rel, "..") {
-		// If the relative path starts with "..", targpath is outside basepath
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		// If the relative path climbs up, targpath is outside basepath;
+		// an element like "..foo" is a name inside it
 		return false
 	}
 
@@ -82,3 +84,28 @@ func joinPath(dir, fn string) string {
 	}
 	return dir + string(PathSeparator) + fn
 }
+
+// isReadable reports whether a file opened with flag can be read.
+func isReadable(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != os.O_WRONLY
+}
+
+// isWritable reports whether a file opened with flag can be written.
+func isWritable(flag int) bool {
+	return flag&(os.O_WRONLY|os.O_RDWR) != 0
+}
+
+// isExclusive reports whether flag requests the exclusive creation
+// of a file.
+func isExclusive(flag int) bool {
+	return flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL
+}
+
+// isStreaming reports whether a file opened with flag is only written
+// sequentially from an empty content, so it can be streamed to S3.
+func isStreaming(flag int) bool {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND) != os.O_WRONLY {
+		return false
+	}
+	return flag&os.O_TRUNC != 0 || isExclusive(flag)
+}
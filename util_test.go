@@ -2,6 +2,8 @@ package s3fs
 
 import (
 	"fmt"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -36,6 +38,10 @@ func TestIsSubPath(t *testing.T) {
 		// Edge cases related to normalization
 		{"foo", "foo/bar/..", true},
 		{"foo", "foo/bar/baz/..", true},
+
+		// Elements starting with dots
+		{"foo", "foo/..bar", true},
+		{"foo", "foo/...", true},
 	}
 
 	for _, tt := range tests {
@@ -48,6 +54,24 @@ func TestIsSubPath(t *testing.T) {
 	}
 }
 
+// FuzzIsSubPath checks isSubPath against the definition of a descendant
+// key: the key itself or a key under its prefix.
+func FuzzIsSubPath(f *testing.F) {
+	for _, seed := range [][2]string{
+		{"foo", "foo/bar"}, {"foo", "foobar"}, {"foo", "foo/..bar"}, {"foo", "foo/../bar"},
+		{"", "..foo"}, {"foo/bar", "foo/bar.."}, {"foo", "foo%2F.."}, {"foo", "foo/\x00/.."},
+	} {
+		f.Add(seed[0], seed[1])
+	}
+	f.Fuzz(func(t *testing.T, base, targ string) {
+		base, targ = vfsKey(base), vfsKey(targ)
+		want := targ == base || strings.HasPrefix(targ, dirPrefix(base))
+		if got := isSubPath(base, targ); got != want {
+			t.Errorf("isSubPath(%q, %q) = %v; want %v", base, targ, got, want)
+		}
+	})
+}
+
 func TestPrefixAndSuffix(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -202,3 +226,34 @@ func TestJoinPath(t *testing.T) {
 		})
 	}
 }
+
+func TestOpenFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		flag     int
+		readable bool
+		writable bool
+		excl     bool
+		stream   bool
+	}{
+		{"read only", os.O_RDONLY, true, false, false, false},
+		{"write only", os.O_WRONLY, false, true, false, false},
+		{"read write", os.O_RDWR, true, true, false, false},
+		{"create truncate", os.O_WRONLY | os.O_CREATE | os.O_TRUNC, false, true, false, true},
+		{"read write truncate", os.O_RDWR | os.O_CREATE | os.O_TRUNC, true, true, false, false},
+		{"append", os.O_RDWR | os.O_APPEND, true, true, false, false},
+		{"write only append", os.O_WRONLY | os.O_CREATE | os.O_TRUNC | os.O_APPEND, false, true, false, false},
+		{"exclusive create", os.O_RDWR | os.O_CREATE | os.O_EXCL, true, true, true, false},
+		{"write only exclusive create", os.O_WRONLY | os.O_CREATE | os.O_EXCL, false, true, true, true},
+		{"exclusive without create", os.O_RDWR | os.O_EXCL, true, true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.readable, isReadable(tt.flag))
+			assert.Equal(t, tt.writable, isWritable(tt.flag))
+			assert.Equal(t, tt.excl, isExclusive(tt.flag))
+			assert.Equal(t, tt.stream, isStreaming(tt.flag))
+		})
+	}
+}